@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authentication settings.
+//
+//	API_KEYS         "<name>:<key>,..." for clients allowed to write; when it's
+//	                 empty writes are open, as before
+//	ADMIN_API_KEY    guards /admin routes; when it's empty the admin API is disabled
+//	AUTH_ALL_ROUTES  true extends requireAPIKey from writes to every route except
+//	                 the probes, for deployments where reads are private too
+
+// principalKey is the gin context key holding the authenticated key name.
+const principalKey = "principal"
+
+// parseAPIKeys parses API_KEYS into key name -> key.
+func parseAPIKeys(s string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, entry := range splitList(s) {
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("API_KEYS entries must look like name:key, got %q", entry)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// requireAPIKey returns middleware that rejects requests without one of
+// apiKeys (or adminKey), sent as "Authorization: Bearer <key>" or
+// "X-API-Key: <key>": 401 when no key is sent, 403 when it's not one of
+// ours. It records the key's name ("admin" for the admin key) as the
+// request's principal; the key itself is never stored or logged.
+// Everything is let through when no keys are configured.
+func requireAPIKey(apiKeys map[string]string, adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkAPIKey(c, apiKeys, adminKey)
+	}
+}
+
+// checkAPIKey is requireAPIKey for one request.
+func checkAPIKey(c *gin.Context, apiKeys map[string]string, adminKey string) {
+	if len(apiKeys) == 0 {
+		c.Next()
+		return
+	}
+	token, ok := presentedKey(c)
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer realm="api"`)
+		abortError(c, http.StatusUnauthorized, gin.H{"error": "API key required"})
+		return
+	}
+	// Compare against every key so timing doesn't reveal which one is close
+	name := ""
+	for n, key := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			name = n
+		}
+	}
+	if name == "" && isAdmin(c, adminKey) {
+		name = "admin"
+	}
+	if name == "" {
+		abortError(c, http.StatusForbidden, gin.H{"error": "invalid API key"})
+		return
+	}
+	c.Set(principalKey, name)
+	c.Next()
+}
+
+// requireAPIKeyExceptProbes is requireAPIKey for AUTH_ALL_ROUTES. Probes
+// stay open, since orchestrators and scrapers don't carry keys.
+func requireAPIKeyExceptProbes(apiKeys map[string]string, adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if probeRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		checkAPIKey(c, apiKeys, adminKey)
+	}
+}
+
+// requireAdmin returns middleware that rejects requests without adminKey
+// (ADMIN_API_KEY), sent like an API key: 401 when no key is sent, 403 when
+// it's the wrong one.
+func requireAdmin(adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminKey == "" {
+			abortError(c, http.StatusForbidden, gin.H{"error": "admin API is disabled"})
+			return
+		}
+		if _, ok := presentedKey(c); !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			abortError(c, http.StatusUnauthorized, gin.H{"error": "admin API key required"})
+			return
+		}
+		if !isAdmin(c, adminKey) {
+			abortError(c, http.StatusForbidden, gin.H{"error": "invalid admin API key"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdmin reports whether the request carries adminKey, the admin API key.
+// Handlers use it to unlock admin-only options on public routes.
+func isAdmin(c *gin.Context, adminKey string) bool {
+	token, ok := presentedKey(c)
+	return ok && adminKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminKey)) == 1
+}
+
+// presentedKey returns the key from "Authorization: Bearer <key>", or else
+// from "X-API-Key: <key>".
+func presentedKey(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+		return token, true
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key, true
+	}
+	return "", false
+}
@@ -0,0 +1,99 @@
+package main
+
+import "testing"
+
+func TestAPIKeyAuth(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIKeys = map[string]string{"ci": "ci-secret", "ops": "ops-secret"}
+	cfg.AdminAPIKey = "admin-secret"
+
+	const body = `{"name":"Alan","email":"alan@example.com"}`
+	tests := []struct {
+		name          string
+		method, path  string
+		header        []string
+		status        int
+		authenticate  bool   // WWW-Authenticate expected
+		wantPrincipal string // logged principal, for accepted writes
+	}{
+		{"missing header", "POST", "/users", nil, 401, true, ""},
+		{"empty bearer", "POST", "/users", []string{"Authorization", "Bearer "}, 401, true, ""},
+		{"wrong scheme", "POST", "/users", []string{"Authorization", "Basic Y2k6Y2ktc2VjcmV0"}, 401, true, ""},
+		{"no scheme", "POST", "/users", []string{"Authorization", "ci-secret"}, 401, true, ""},
+		{"wrong key", "POST", "/users", []string{"Authorization", "Bearer nope"}, 403, false, ""},
+		{"key prefix", "POST", "/users", []string{"Authorization", "Bearer ci-secre"}, 403, false, ""},
+		{"bearer", "POST", "/users", []string{"Authorization", "Bearer ci-secret"}, 201, false, "ci"},
+		{"lowercase scheme", "POST", "/users", []string{"Authorization", "bearer ops-secret"}, 201, false, "ops"},
+		{"x-api-key", "POST", "/users", []string{"X-API-Key", "ops-secret"}, 201, false, "ops"},
+		{"admin key writes", "POST", "/users", []string{"Authorization", "Bearer admin-secret"}, 201, false, "admin"},
+		{"delete needs a key", "DELETE", "/users/1", nil, 401, true, ""},
+		{"restore needs the admin key", "POST", "/admin/users/1/restore", []string{"Authorization", "Bearer ci-secret"}, 403, false, ""},
+		{"restore with the admin key", "POST", "/admin/users/1/restore", []string{"Authorization", "Bearer admin-secret"}, 404, false, ""},
+		{"reads stay open", "GET", "/users/1", nil, 200, false, ""},
+		{"probes stay open", "GET", "/healthz", nil, 200, false, ""},
+		{"admin option needs the admin key", "GET", "/users?include_deleted=true", []string{"Authorization", "Bearer ci-secret"}, 403, false, ""},
+		{"admin option", "GET", "/users?include_deleted=true", []string{"Authorization", "Bearer admin-secret"}, 200, false, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			var b string
+			if tc.method == "POST" {
+				b = body
+			}
+			w := serve(testRouter(t, seedUsers(), cfg), tc.method, tc.path, b, tc.header...)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d\n%s", w.Code, tc.status, w.Body)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); (got != "") != tc.authenticate {
+				t.Errorf("WWW-Authenticate = %q", got)
+			}
+			if tc.wantPrincipal != "" {
+				if got := requestLogs(t, logs)[0]["principal"]; got != tc.wantPrincipal {
+					t.Errorf("principal = %v, want %q", got, tc.wantPrincipal)
+				}
+			}
+		})
+	}
+}
+
+// AUTH_ALL_ROUTES closes reads too, but never the probes.
+func TestAuthAllRoutes(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIKeys = map[string]string{"ci": "ci-secret"}
+	cfg.AuthAllRoutes = true
+	r := testRouter(t, seedUsers(), cfg)
+
+	if w := serve(r, "GET", "/users/1", ""); w.Code != 401 {
+		t.Errorf("read without a key = %d, want 401", w.Code)
+	}
+	if w := serve(r, "GET", "/users/1", "", "Authorization", "Bearer ci-secret"); w.Code != 200 {
+		t.Errorf("read with a key = %d, want 200", w.Code)
+	}
+	if w := serve(r, "GET", "/healthz", ""); w.Code != 200 {
+		t.Errorf("probe without a key = %d, want 200", w.Code)
+	}
+}
+
+// Without API_KEYS writes are open, as they were before keys existed.
+func TestNoAPIKeys(t *testing.T) {
+	w := serve(testRouter(t, seedUsers(), testConfig(t)), "DELETE", "/users/1", "")
+	if w.Code != 200 {
+		t.Errorf("DELETE without keys configured = %d, want 200", w.Code)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys(" ci:abc , ops:d:e ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys["ci"] != "abc" || keys["ops"] != "d:e" {
+		t.Errorf("parseAPIKeys = %v", keys)
+	}
+	for _, bad := range []string{"nocolon", ":key", "name:"} {
+		if _, err := parseAPIKeys(bad); err == nil {
+			t.Errorf("parseAPIKeys(%q) succeeded, want an error", bad)
+		}
+	}
+}
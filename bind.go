@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// bindJSON reads the request body into dst, validating it on the way.
+// It writes the error response itself and returns false on failure.
+//
+// Beyond plain JSON decoding it enforces text hygiene up front, because Postgres
+// answers bad text with an opaque 500:
+//   - `text`-tagged strings are trimmed before `binding` rules run, and rule
+//     failures come back as {"errors": {"<field>": "<message>"}}
+//   - an empty (or all-whitespace) body is a 400 empty_body, not a JSON error
+//   - the raw body must be valid UTF-8 (422 invalid_utf8); encoding/json would
+//     otherwise silently replace bad bytes with U+FFFD
+//   - string fields tagged `text:"<max runes>,<max bytes>"` must contain no
+//     control characters (422 control_characters) and fit both limits
+//     (422 too_long), since columns are sized in bytes but users count runes
+func bindJSON(c *gin.Context, dst any) bool {
+	if !decodeJSON(c, dst) {
+		return false
+	}
+	if status, body := validateBody(dst); status != 0 {
+		respondError(c, status, body)
+		return false
+	}
+	return true
+}
+
+// decodeJSON is the first half of bindJSON: it reads the body into dst
+// (which may be a slice) with the UTF-8 check, but runs no validation.
+func decodeJSON(c *gin.Context, dst any) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	// Say so plainly rather than with json's "unexpected end of JSON input"
+	if len(bytes.TrimSpace(body)) == 0 {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "request body is empty", "code": "empty_body"})
+		return false
+	}
+	if !utf8.Valid(body) {
+		respondError(c, http.StatusUnprocessableEntity, gin.H{"error": "request body is not valid UTF-8", "code": "invalid_utf8"})
+		return false
+	}
+	if err := json.Unmarshal(body, dst); err != nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// validateBody is the second half of bindJSON: it trims and validates the
+// struct dst points to. It returns the error status and body, or 0 if dst is
+// valid, so callers checking several values can label each failure.
+func validateBody(dst any) (int, gin.H) {
+	trimText(dst)
+	if err := binding.Validator.ValidateStruct(dst); err != nil {
+		if fields := fieldErrors(err); fields != nil {
+			return http.StatusBadRequest, gin.H{"errors": fields}
+		}
+		return http.StatusBadRequest, gin.H{"error": err.Error()}
+	}
+	if field, code, msg := checkText(dst); code != "" {
+		return http.StatusUnprocessableEntity, gin.H{"error": field + " " + msg, "code": code}
+	}
+	return 0, nil
+}
+
+func init() {
+	// Report validation failures by JSON key ("email"), not Go field name ("Email").
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonName)
+	}
+}
+
+// fieldErrors turns validator errors into {"<json field>": "<message>"},
+// or returns nil if err isn't a validation error.
+func fieldErrors(err error) map[string]string {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return nil
+	}
+	fields := make(map[string]string, len(verrs))
+	for _, fe := range verrs {
+		fields[fe.Field()] = describeRule(fe)
+	}
+	return fields
+}
+
+// describeRule explains a single failed validation rule.
+func describeRule(fe validator.FieldError) string {
+	// min/max count characters for strings and elements for lists
+	unit := " characters"
+	if k := fe.Kind(); k == reflect.Slice || k == reflect.Array {
+		unit = " items"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fe.Param() == "1" {
+			return "must not be empty"
+		}
+		return "must be at least " + fe.Param() + unit
+	case "max":
+		return "must be at most " + fe.Param() + unit
+	case "gt":
+		return "must be greater than " + fe.Param()
+	default:
+		return "failed the " + fe.Tag() + " check"
+	}
+}
+
+// trimText strips surrounding whitespace from every `text`-tagged string
+// field, so "  " fails a required check instead of being stored.
+func trimText(dst any) {
+	v := reflect.Indirect(reflect.ValueOf(dst))
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("text"); !ok {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.String && fv.CanSet() {
+			fv.SetString(strings.TrimSpace(fv.String()))
+		}
+	}
+}
+
+// checkText validates every `text`-tagged string field of the struct dst points to.
+// It returns the offending JSON field name, an error code and a message, or
+// an empty code when everything is fine.
+func checkText(dst any) (field, code, msg string) {
+	v := reflect.Indirect(reflect.ValueOf(dst))
+	if v.Kind() != reflect.Struct {
+		return "", "", ""
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("text")
+		if !ok {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() != reflect.String {
+			continue
+		}
+		name := jsonName(t.Field(i))
+		maxRunes, maxBytes := parseTextTag(tag)
+		s := fv.String()
+
+		if strings.ContainsFunc(s, unicode.IsControl) {
+			return name, "control_characters", "must not contain control characters"
+		}
+		if n := utf8.RuneCountInString(s); maxRunes > 0 && n > maxRunes {
+			return name, "too_long", fmt.Sprintf("must be at most %d characters", maxRunes)
+		}
+		if maxBytes > 0 && len(s) > maxBytes {
+			return name, "too_long", fmt.Sprintf("must be at most %d bytes", maxBytes)
+		}
+	}
+	return "", "", ""
+}
+
+// bindingRules are the constraints of a `binding` tag that the published
+// schemas (/openapi.json, /users/schema) describe. Other rules are left out.
+type bindingRules struct {
+	Required bool
+	Email    bool
+	Min, Max int // string length limits; -1 when absent
+}
+
+// parseBindingTag parses a `binding:"required,email,min=1,max=100"` tag.
+func parseBindingTag(tag string) bindingRules {
+	b := bindingRules{Min: -1, Max: -1}
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(rule, "=")
+		n, _ := strconv.Atoi(arg)
+		switch rule {
+		case "required":
+			b.Required = true
+		case "email":
+			b.Email = true
+		case "min":
+			b.Min = n
+		case "max":
+			b.Max = n
+		}
+	}
+	return b
+}
+
+// constrain adds b's format and length keywords to the JSON Schema s. The
+// length limits only apply to strings.
+func (b bindingRules) constrain(s gin.H, isString bool) {
+	if b.Email {
+		s["format"] = "email"
+	}
+	if isString && b.Min >= 0 {
+		s["minLength"] = b.Min
+	}
+	if isString && b.Max >= 0 {
+		s["maxLength"] = b.Max
+	}
+}
+
+// parseTextTag parses a `text:"<runes>,<bytes>"` tag; 0 means unlimited.
+func parseTextTag(tag string) (runes, bytes int) {
+	r, b, _ := strings.Cut(tag, ",")
+	runes, _ = strconv.Atoi(r)
+	bytes, _ = strconv.Atoi(b)
+	return runes, bytes
+}
+
+// jsonName returns the JSON key used for a struct field.
+func jsonName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return f.Name
+}
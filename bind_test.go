@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// zalgo returns n runes of "z" stacked with combining marks: few
+// characters on screen, but 2-byte runes after the first of each group.
+func zalgo(n int) string {
+	marks := []rune("\u0336\u0337\u0338")
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		if i%4 == 0 {
+			b.WriteRune('z')
+		} else {
+			b.WriteRune(marks[i%4-1])
+		}
+	}
+	return b.String()
+}
+
+func TestBindText(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"latin-1 name", "/users", "{\"name\":\"Jos\xe9\",\"email\":\"jose@example.com\"}", 422, "invalid_utf8"},
+		{"latin-1 in a batch row", "/users/batch", "[{\"name\":\"Jos\xe9\",\"email\":\"jose@example.com\"}]", 422, "invalid_utf8"},
+		{"null byte", "/users", `{"name":"Ada\u0000","email":"nul@example.com"}`, 422, "control_characters"},
+		{"escape sequence", "/users", `{"name":"\u001b[31mAda","email":"esc@example.com"}`, 422, "control_characters"},
+		{"zalgo at the limit", "/users", `{"name":"` + zalgo(200) + `","email":"z@example.com"}`, 201, ""},
+		{"zalgo past the limit", "/users", `{"name":"` + zalgo(201) + `","email":"z@example.com"}`, 400, ""},
+		{"emoji at the limit", "/users", `{"name":"` + strings.Repeat("🦄", 200) + `","email":"u@example.com"}`, 201, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRouter(t, seedUsers(), testConfig(t))
+			w := serve(r, "POST", tc.target, tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("POST %s = %d, want %d\n%s", tc.target, w.Code, tc.status, w.Body)
+			}
+			if code := jsonObject(t, w)["code"]; tc.code != "" && code != tc.code {
+				t.Errorf("code = %v, want %q", code, tc.code)
+			}
+		})
+	}
+}
+
+// A control character in one row of a partial batch fails only that row.
+func TestBatchRowText(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	w := serve(r, "POST", "/users/batch?partial=true",
+		`[{"name":"A","email":"a@example.com"},{"name":"B\u0000","email":"b@example.com"}]`)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200\n%s", w.Code, w.Body)
+	}
+	results := jsonObject(t, w)["results"].([]any)
+	bad := results[1].(map[string]any)
+	if bad["index"] != 1.0 || bad["status"] != 422.0 || bad["code"] != "control_characters" {
+		t.Errorf("results[1] = %v, want index 1, status 422, code control_characters", bad)
+	}
+}
+
+func TestCheckText(t *testing.T) {
+	type body struct {
+		S string  `json:"s" text:"5,8"`
+		P *string `json:"p" text:"5,8"`
+		U string  `json:"u"`
+	}
+	ptr := func(s string) *string { return &s }
+	tests := []struct {
+		name string
+		in   body
+		code string
+	}{
+		{"fits", body{S: "abcde"}, ""},
+		{"too many runes", body{S: "abcdef"}, "too_long"},
+		{"runes fit, bytes don't", body{S: "ééééé"}, "too_long"},
+		{"bytes at the limit", body{S: "éééé"}, ""},
+		{"pointer field", body{P: ptr("a\tb")}, "control_characters"},
+		{"nil pointer", body{P: nil}, ""},
+		{"untagged field", body{U: "a\x00" + strings.Repeat("x", 100)}, ""},
+	}
+	for _, tc := range tests {
+		if _, code, _ := checkText(&tc.in); code != tc.code {
+			t.Errorf("%s: code = %q, want %q", tc.name, code, tc.code)
+		}
+	}
+}
+
+// A missing body gets its own error, not a JSON syntax error.
+func TestEmptyBody(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	for _, req := range []struct{ method, target, body string }{
+		{"POST", "/users", ""},
+		{"POST", "/users", " \r\n\t"},
+		{"PUT", "/users/1", ""},
+		{"PATCH", "/users/1", ""},
+		{"POST", "/users/batch", ""},
+		{"POST", "/users/touch", ""},
+		{"POST", "/users/1/counters", ""},
+	} {
+		w := serve(r, req.method, req.target, req.body)
+		if w.Code != 400 {
+			t.Errorf("%s %s with body %q = %d, want 400", req.method, req.target, req.body, w.Code)
+			continue
+		}
+		if body := jsonObject(t, w); body["code"] != "empty_body" || body["error"] != "request body is empty" {
+			t.Errorf("%s %s with body %q: %v, want code empty_body", req.method, req.target, req.body, body)
+		}
+	}
+
+	for _, malformed := range []string{`{"name":`, `null x`, `"name"`} {
+		w := serve(r, "POST", "/users", malformed)
+		if body := jsonObject(t, w); w.Code != 400 || body["code"] == "empty_body" {
+			t.Errorf("malformed body %q = %d %v, want a 400 that isn't empty_body", malformed, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cache-Control for browsers, proxies and CDNs. Each cacheable read route has
+// its own max-age; at 0 (the default) caches may still store a response but
+// must revalidate it first, which the ETag on single users makes cheap.
+//
+//	CACHE_MAX_AGE_USERS  GET /users (default 0)
+//	CACHE_MAX_AGE_USER   GET /users/:id and /users/by-email/:email (default 0)
+//
+// A response to a request carrying an API key is private: the client may
+// keep it, shared caches may not, since whoever asks next may not be allowed
+// to see it. Everything else is public. Writes, admin endpoints and error
+// responses are never stored (see noStore, respondError).
+
+// cacheFor returns middleware that sets Cache-Control on a read route.
+// Headers that select a different representation are added to Vary.
+func cacheFor(maxAge time.Duration, vary ...string) gin.HandlerFunc {
+	freshness := "no-cache"
+	if maxAge > 0 {
+		freshness = "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+	return func(c *gin.Context) {
+		scope := "public"
+		if _, ok := presentedKey(c); ok {
+			scope = "private"
+		}
+		c.Header("Cache-Control", scope+", "+freshness)
+		for _, h := range vary {
+			c.Writer.Header().Add("Vary", h)
+		}
+		c.Next()
+	}
+}
+
+// noStore marks every response of a route as not to be cached.
+func noStore(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Next()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// setEnv sets name, value pairs for the rest of the test. An empty value
+// counts as unset, which is how LoadConfig reads it.
+func setEnv(t *testing.T, kv ...string) {
+	t.Helper()
+	for i := 0; i+1 < len(kv); i += 2 {
+		t.Setenv(kv[i], kv[i+1])
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setEnv(t, "APP_ENV", "", "LISTEN_ADDR", "", "PORT", "", "GIN_MODE", "", "DB_URL", "",
+		"DB_MAX_CONNS", "", "DB_CONNECT_TIMEOUT", "", "HTTP_READ_TIMEOUT", "",
+		"REQUEST_TIMEOUT", "", "REQUEST_TIMEOUT_SAFE", "", "LOG_LEVEL", "")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":8080" || cfg.DBURL != devDBURL || cfg.GinMode != "debug" {
+		t.Errorf("ListenAddr %q, DBURL %q, GinMode %q: want the development defaults", cfg.ListenAddr, cfg.DBURL, cfg.GinMode)
+	}
+	if cfg.DBConnectTimeout != 5*time.Second || cfg.ReadTimeout != 15*time.Second || cfg.SafeRequestTimeout != 5*time.Second {
+		t.Errorf("timeouts %v, %v, %v: want 5s, 15s, 5s", cfg.DBConnectTimeout, cfg.ReadTimeout, cfg.SafeRequestTimeout)
+	}
+	if cfg.DBMaxConns != 0 || cfg.LogLevel != slog.LevelInfo {
+		t.Errorf("DBMaxConns %d, LogLevel %v: want pgx's pool size and info", cfg.DBMaxConns, cfg.LogLevel)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	setEnv(t, "APP_ENV", "production", "PORT", "9000", "LISTEN_ADDR", "", "GIN_MODE", "",
+		"DB_URL", "postgres://db/app", "DB_MAX_CONNS", "20", "DB_MIN_CONNS", "2",
+		"REQUEST_TIMEOUT", "3s", "REQUEST_TIMEOUT_UNSAFE", "10s", "LOG_LEVEL", "warn",
+		"API_KEYS", "ci:abc", "TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":9000" || cfg.GinMode != "release" || cfg.DBURL != "postgres://db/app" {
+		t.Errorf("ListenAddr %q, GinMode %q, DBURL %q", cfg.ListenAddr, cfg.GinMode, cfg.DBURL)
+	}
+	if cfg.DBMaxConns != 20 || cfg.DBMinConns != 2 {
+		t.Errorf("pool %d..%d, want 2..20", cfg.DBMinConns, cfg.DBMaxConns)
+	}
+	if cfg.SafeRequestTimeout != 3*time.Second || cfg.UnsafeRequestTimeout != 10*time.Second {
+		t.Errorf("request timeouts %v/%v, want 3s/10s", cfg.SafeRequestTimeout, cfg.UnsafeRequestTimeout)
+	}
+	if cfg.LogLevel != slog.LevelWarn || cfg.APIKeys["ci"] != "abc" || len(cfg.TrustedProxies) != 2 {
+		t.Errorf("LogLevel %v, APIKeys %v, TrustedProxies %q", cfg.LogLevel, cfg.APIKeys, cfg.TrustedProxies)
+	}
+}
+
+// Every bad setting is reported by one LoadConfig error.
+func TestLoadConfigProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		want []string
+	}{
+		{"production without DB_URL", []string{"APP_ENV", "production", "DB_URL", ""},
+			[]string{"DB_URL is required when APP_ENV=production"}},
+		{"bad port", []string{"LISTEN_ADDR", "", "PORT", "http"},
+			[]string{`PORT must be a port number, got "http"`}},
+		{"several at once", []string{"DB_MAX_CONNS", "lots", "HTTP_READ_TIMEOUT", "15", "LOG_LEVEL", "loud", "GIN_MODE", "prod"},
+			[]string{
+				`DB_MAX_CONNS must be an integer, got "lots"`,
+				`HTTP_READ_TIMEOUT must be a duration like 10s, got "15"`,
+				`LOG_LEVEL must be debug, info, warn or error, got "loud"`,
+				`GIN_MODE must be debug, release or test, got "prod"`,
+			}},
+		{"pool bounds", []string{"DB_MAX_CONNS", "2", "DB_MIN_CONNS", "5"},
+			[]string{"DB_MIN_CONNS must not exceed DB_MAX_CONNS"}},
+		{"non-positive connect timeout", []string{"DB_CONNECT_TIMEOUT", "0s"},
+			[]string{"DB_CONNECT_TIMEOUT must be positive"}},
+		{"bad proxy", []string{"TRUSTED_PROXIES", "10.0.0.0/33"},
+			[]string{`TRUSTED_PROXIES entries must be IPs or CIDRs, got "10.0.0.0/33"`}},
+		{"negative statement cache", []string{"DB_STATEMENT_CACHE_CAPACITY", "-1"},
+			[]string{"DB_STATEMENT_CACHE_CAPACITY must not be negative"}},
+		{"bad API key entry", []string{"API_KEYS", "justakey"},
+			[]string{`API_KEYS entries must look like name:key, got "justakey"`}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setEnv(t, tc.env...)
+			_, err := LoadConfig()
+			if err == nil {
+				t.Fatal("LoadConfig succeeded, want an error")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not mention %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+// A disabled statement cache also needs an exec mode that works without one.
+func TestStatementCacheDisabled(t *testing.T) {
+	for _, tc := range []struct {
+		capacity string
+		want     pgx.QueryExecMode
+	}{
+		{"", pgx.QueryExecModeCacheStatement},
+		{"16", pgx.QueryExecModeCacheStatement},
+		{"0", pgx.QueryExecModeDescribeExec},
+	} {
+		setEnv(t, "DB_STATEMENT_CACHE_CAPACITY", tc.capacity)
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err := poolConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := pc.ConnConfig.DefaultQueryExecMode; mode != tc.want {
+			t.Errorf("DB_STATEMENT_CACHE_CAPACITY=%q: exec mode %v, want %v", tc.capacity, mode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS for browser clients. Off unless CORS_ALLOWED_ORIGINS is set, so no
+// origin is trusted by accident.
+//
+//	CORS_ALLOWED_ORIGINS    comma-separated origins like https://app.example.com, subdomain
+//	                        patterns like https://*.example.com, or * for any (dev only)
+//	CORS_ALLOWED_METHODS    methods preflights may ask for (default GET,POST,PUT,PATCH,DELETE)
+//	CORS_ALLOWED_HEADERS    request headers preflights may ask for (default: the ones the API reads)
+//	CORS_ALLOW_CREDENTIALS  true lets browsers send cookies and Authorization (not with *)
+//	CORS_MAX_AGE            how long browsers may cache a preflight answer (default 10m, 0 = don't say)
+
+// corsExposed are the response headers browser scripts may read.
+var corsExposed = []string{
+	"ETag", "Idempotent-Replayed", "Link", "Preference-Applied", "Retry-After", requestIDHeader,
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-Search-Mode", "X-Total-Count", "X-Next-Cursor",
+}
+
+// checkCORS validates the CORS settings in cfg, returning a problem per
+// mistake for LoadConfig.
+func checkCORS(cfg Config) []string {
+	var problems []string
+	if slices.Contains(cfg.CORSOrigins, "*") && cfg.CORSCredentials {
+		problems = append(problems, "CORS_ALLOW_CREDENTIALS can't be combined with CORS_ALLOWED_ORIGINS=*")
+	}
+	for _, o := range cfg.CORSOrigins {
+		if o != "*" && strings.Contains(o, "*") && (strings.Count(o, "*") > 1 || !strings.Contains(o, "://*.")) {
+			problems = append(problems, fmt.Sprintf("CORS_ALLOWED_ORIGINS patterns must look like https://*.example.com, got %q", o))
+		}
+	}
+	return problems
+}
+
+// newCORS returns the CORS middleware for cfg (checked by checkCORS), or
+// nil when no origins are allowed. A preflight (OPTIONS with
+// Access-Control-Request-Method) is answered here with 204 and never
+// reaches a handler; other requests from an allowed origin get
+// Access-Control-Allow-Origin and go on as usual. Requests from other
+// origins get no CORS headers, so the browser blocks them.
+func newCORS(cfg Config) gin.HandlerFunc {
+	if len(cfg.CORSOrigins) == 0 {
+		return nil
+	}
+	origins := cfg.CORSOrigins
+	anyOrigin := slices.Contains(origins, "*")
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+	methods := strings.Join(cfg.CORSMethods, ", ")
+	headers := strings.Join(cfg.CORSHeaders, ", ")
+	exposed := strings.Join(corsExposed, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		// The answer depends on Origin, so caches must keep them apart
+		c.Writer.Header().Add("Vary", "Origin")
+		if anyOrigin || originAllowed(origins, origin) {
+			if anyOrigin {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.CORSCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				c.Header("Access-Control-Allow-Methods", methods)
+				c.Header("Access-Control-Allow-Headers", headers)
+				if cfg.CORSMaxAge > 0 {
+					c.Header("Access-Control-Max-Age", maxAge)
+				}
+			} else {
+				c.Header("Access-Control-Expose-Headers", exposed)
+			}
+		}
+
+		if preflight {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// originAllowed reports whether origin matches one of origins
+// (CORS_ALLOWED_ORIGINS). A
+// pattern like https://*.example.com matches any subdomain at any depth
+// (https://app.example.com, https://a.b.example.com), but neither
+// https://example.com itself nor another scheme or port.
+func originAllowed(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		prefix, suffix, wildcard := strings.Cut(allowed, "*")
+		if !wildcard {
+			if origin == allowed {
+				return true
+			}
+			continue
+		}
+		sub, hasPrefix := strings.CutPrefix(origin, prefix)
+		sub, hasSuffix := strings.CutSuffix(sub, suffix)
+		if hasPrefix && hasSuffix && sub != "" && !strings.ContainsAny(sub, "/:@") {
+			return true
+		}
+	}
+	return false
+}
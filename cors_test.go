@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func corsConfig(t *testing.T) Config {
+	cfg := testConfig(t)
+	cfg.CORSOrigins = []string{"https://app.example.com", "https://*.example.org"}
+	cfg.CORSMaxAge = 10 * time.Minute
+	return cfg
+}
+
+func TestCORSPreflight(t *testing.T) {
+	repo := seedUsers()
+	r := testRouter(t, repo, corsConfig(t))
+
+	w := serve(r, "OPTIONS", "/users/1", "",
+		"Origin", "https://app.example.com",
+		"Access-Control-Request-Method", "DELETE",
+		"Access-Control-Request-Headers", "Authorization")
+	if w.Code != 204 || w.Body.Len() != 0 {
+		t.Fatalf("preflight = %d %q, want an empty 204", w.Code, w.Body)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+		"Access-Control-Max-Age":       "600",
+		"Vary":                         "Origin",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("credentials allowed without CORS_ALLOW_CREDENTIALS")
+	}
+	// The handler never ran: user 1 is still there
+	if w := serve(r, "GET", "/users/1", ""); w.Code != 200 {
+		t.Errorf("preflight reached the DELETE handler")
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	tests := []struct {
+		name, method, origin string
+		header               []string
+		status               int
+		allowOrigin          string
+	}{
+		{"simple request", "GET", "https://app.example.com", nil, 200, "https://app.example.com"},
+		{"wildcard subdomain", "GET", "https://admin.example.org", nil, 200, "https://admin.example.org"},
+		{"nested subdomain", "GET", "https://a.b.example.org", nil, 200, "https://a.b.example.org"},
+		{"disallowed origin", "GET", "https://evil.example.net", nil, 200, ""},
+		{"wildcard excludes the apex", "GET", "https://example.org", nil, 200, ""},
+		{"wildcard excludes other schemes", "GET", "http://app.example.org", nil, 200, ""},
+		{"disallowed preflight", "OPTIONS", "https://evil.example.net", []string{"Access-Control-Request-Method", "GET"}, 204, ""},
+		{"no origin", "GET", "", nil, 200, ""},
+	}
+	r := testRouter(t, seedUsers(), corsConfig(t))
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, tc.method, "/users", "", append([]string{"Origin", tc.origin}, tc.header...)...)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d", w.Code, tc.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tc.allowOrigin)
+			}
+			if tc.allowOrigin != "" && w.Header().Get("Access-Control-Expose-Headers") == "" && tc.method != "OPTIONS" {
+				t.Error("allowed response exposes no headers")
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.CORSOrigins = []string{"*"}
+	w := serve(testRouter(t, seedUsers(), cfg), "GET", "/users", "", "Origin", "https://anywhere.test")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	origins := []string{"https://app.example.com", "https://*.example.org", "http://*.local.test:3000"}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://app.example.com:443", false},
+		{"https://other.example.com", false},
+		{"https://x.example.org", true},
+		{"https://x.y.example.org", true},
+		{"https://example.org", false},
+		{"https://.example.org", false},
+		{"https://x.example.org.evil.net", false},
+		{"https://evil.net/.example.org", false},
+		{"https://user@x.example.org", false},
+		{"http://dev.local.test:3000", true},
+		{"http://dev.local.test:3001", false},
+		{"http://dev.local.test", false},
+	}
+	for _, tc := range tests {
+		if got := originAllowed(origins, tc.origin); got != tc.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", tc.origin, got, tc.want)
+		}
+	}
+}
+
+func TestCheckCORS(t *testing.T) {
+	tests := []struct {
+		origins     []string
+		credentials bool
+		problems    int
+	}{
+		{[]string{"https://app.example.com", "https://*.example.org"}, true, 0},
+		{[]string{"*"}, false, 0},
+		{[]string{"*"}, true, 1},
+		{[]string{"https://app.*.com"}, false, 1},
+		{[]string{"https://*.*.example.org"}, false, 1},
+		{[]string{"*.example.org"}, false, 1},
+	}
+	for _, tc := range tests {
+		cfg := Config{CORSOrigins: tc.origins, CORSCredentials: tc.credentials}
+		if got := checkCORS(cfg); len(got) != tc.problems {
+			t.Errorf("checkCORS(%q, credentials %v) = %q, want %d problems", tc.origins, tc.credentials, got, tc.problems)
+		}
+	}
+}
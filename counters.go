@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Counters on users (login_count, profile_view_count) that clients bump
+// with POST /users/:id/counters rather than by writing the whole user, so
+// concurrent increments all count. They're read-only everywhere else.
+//
+// By default a counter change leaves updated_at alone: the user's ETag stays
+// valid and no webhook fires, so busy counters don't invalidate caches or
+// fail If-Match writes. COUNTERS_TOUCH_UPDATED_AT=true makes every change a
+// regular update instead (new updated_at and ETag, user.updated webhook).
+
+// userCounters are the counter columns, which are also their JSON names.
+var userCounters = []string{"login_count", "profile_view_count"}
+
+// ---------------------------------------------------------
+// POST /users/:id/counters -> add to counters atomically
+// ---------------------------------------------------------
+// The body names each counter to change: {"login_count": {"inc": 1}}.
+// A negative inc decrements, but one that would take a counter below zero
+// fails the whole call with 409 counter_negative, and one that would take
+// it past the largest BIGINT with 409 counter_overflow.
+func (h *UserHandler) IncrementCounters(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	var body map[string]struct {
+		Inc *int64 `json:"inc"`
+	}
+	if !decodeJSON(c, &body) {
+		return
+	}
+	if len(body) == 0 {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "no counters given; counters are " + strings.Join(userCounters, ", ")})
+		return
+	}
+	deltas := map[string]int64{}
+	fieldErrs := gin.H{}
+	for name, op := range body {
+		switch {
+		case !slices.Contains(userCounters, name):
+			fieldErrs[name] = "unknown counter; counters are " + strings.Join(userCounters, ", ")
+		case op.Inc == nil:
+			fieldErrs[name] = "inc is required"
+		default:
+			deltas[name] = *op.Inc
+		}
+	}
+	if len(fieldErrs) > 0 {
+		respondError(c, http.StatusBadRequest, gin.H{"errors": fieldErrs})
+		return
+	}
+
+	u, err := h.repo.IncrementCounters(c, id, deltas)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	respondUser(c, http.StatusOK, u)
+}
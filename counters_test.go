@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// incrementConcurrently sends n parallel {"login_count": {"inc": 1}} calls
+// for user id and fails the test unless each one succeeds.
+func incrementConcurrently(t *testing.T, h http.Handler, id, n int) {
+	t.Helper()
+	target := fmt.Sprintf("/users/%d/counters", id)
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve(h, "POST", target, `{"login_count":{"inc":1}}`).Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != 200 {
+			t.Fatalf("increment %d = %d, want 200", i, code)
+		}
+	}
+}
+
+func TestCountersConcurrent(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	before := serve(r, "GET", "/users/1", "").Header().Get("ETag")
+
+	incrementConcurrently(t, r, 1, 100)
+
+	w := serve(r, "GET", "/users/1", "")
+	if got := jsonObject(t, w)["login_count"]; got != 100.0 {
+		t.Errorf("login_count = %v after 100 increments, want 100", got)
+	}
+	// Counters alone don't make the user's ETag stale
+	if after := w.Header().Get("ETag"); after != before {
+		t.Errorf("ETag changed from %s to %s", before, after)
+	}
+}
+
+// The same against Postgres, where the atomicity actually lives. Set
+// TEST_DB_URL to a scratch database to run it.
+func TestCountersConcurrentPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	ctx := context.Background()
+	cfg := testConfig(t)
+	cfg.DBURL = url
+	db, err := ConnectDB(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewUserRepository(db, cfg)
+	email := fmt.Sprintf("counters-%d@example.com", time.Now().UnixNano())
+	u, err := repo.Create(ctx, UserInput{Name: "Counter Test", Email: email, EmailKey: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(ctx, "DELETE FROM users WHERE id=$1", u.ID) })
+
+	incrementConcurrently(t, testRouter(t, repo, cfg), u.ID, 100)
+
+	got, err := repo.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LoginCount != 100 {
+		t.Errorf("login_count = %d after 100 increments, want 100", got.LoginCount)
+	}
+	if !got.UpdatedAt.Equal(u.UpdatedAt) {
+		t.Errorf("updated_at moved from %v to %v", u.UpdatedAt, got.UpdatedAt)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	tests := []struct {
+		name, body string
+		status     int
+		want       map[string]any
+	}{
+		{"several at once", `{"login_count":{"inc":3},"profile_view_count":{"inc":2}}`,
+			200, map[string]any{"login_count": 3.0, "profile_view_count": 2.0}},
+		{"below zero", `{"login_count":{"inc":-1}}`,
+			409, map[string]any{"code": "counter_negative"}},
+		{"unknown counter", `{"id":{"inc":1}}`, 400, nil},
+		{"missing inc", `{"login_count":{}}`, 400, nil},
+		{"no counters", `{}`, 400, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := seedUsers()
+			w := serve(testRouter(t, repo, testConfig(t)), "POST", "/users/1/counters", tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d\n%s", w.Code, tc.status, w.Body)
+			}
+			body := jsonObject(t, w)
+			for k, v := range tc.want {
+				if body[k] != v {
+					t.Errorf("%s = %v, want %v", k, body[k], v)
+				}
+			}
+			// A refused change leaves every counter as it was
+			if tc.status != 200 && repo.users[0].LoginCount != 0 {
+				t.Errorf("login_count = %d after a failed call", repo.users[0].LoginCount)
+			}
+		})
+	}
+}
+
+// Counters can't be written through the regular user routes.
+func TestCountersReadOnly(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	w := serve(r, "PATCH", "/users/1", `{"name":"Ada","login_count":50}`)
+	if got := jsonObject(t, w)["login_count"]; w.Code != 200 || got != 0.0 {
+		t.Errorf("PATCH with login_count = %d, login_count %v; want 200 and 0", w.Code, got)
+	}
+}
+
+// A counter that would pass the largest BIGINT is refused like a negative
+// one, with nothing changed.
+func TestCounterOverflow(t *testing.T) {
+	repo := seedUsers()
+	repo.users[0].LoginCount = 1
+	r := testRouter(t, repo, testConfig(t))
+
+	w := serve(r, "POST", "/users/1/counters", `{"profile_view_count":{"inc":1},"login_count":{"inc":9223372036854775807}}`)
+	if w.Code != 409 || jsonObject(t, w)["code"] != "counter_overflow" {
+		t.Errorf("overflowing increment = %d %s, want 409 counter_overflow", w.Code, w.Body)
+	}
+	if u := repo.users[0]; u.LoginCount != 1 || u.ProfileViewCount != 0 {
+		t.Errorf("counters %d, %d after a refused call, want 1, 0", u.LoginCount, u.ProfileViewCount)
+	}
+	if w := serve(r, "POST", "/users/1/counters", `{"login_count":{"inc":9223372036854775806}}`); w.Code != 200 {
+		t.Errorf("increment up to the largest BIGINT = %d, want 200", w.Code)
+	}
+}
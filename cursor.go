@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// errInvalidCursor is returned for cursors that can't be decoded or don't
+// belong to the requested sort.
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a keyset-paginated listing: the (sort value, id)
+// of the last row seen, plus the sort it was issued for.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value any    `json:"v"` // int for id, string for text columns, time.Time for timestamps
+	ID    int    `json:"id"`
+	MaxID int    `json:"m,omitempty"` // snapshot mode: the highest id when the listing began (see UserFilter.MaxID)
+}
+
+// cursorFor returns the cursor positioned just after u in the listing f.
+func cursorFor(u User, f UserFilter) Cursor {
+	c := Cursor{Sort: f.Sort[0].Column, Order: f.Sort[0].Order, ID: u.ID, MaxID: f.MaxID}
+	switch c.Sort {
+	case "name":
+		c.Value = u.Name
+	case "email":
+		c.Value = u.Email
+	case "created_at":
+		c.Value = u.CreatedAt
+	case "updated_at":
+		c.Value = u.UpdatedAt
+	default:
+		c.Value = u.ID
+	}
+	return c
+}
+
+// encodeCursor returns the opaque (URL-safe base64 JSON) form of c.
+func encodeCursor(c Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses an opaque cursor and checks it was issued for the
+// sort key. Values are converted back to the Go type the column expects.
+func decodeCursor(s string, key SortKey) (Cursor, error) {
+	sort := key.Column
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var c Cursor
+	if err := dec.Decode(&c); err != nil || c.ID <= 0 || c.ID > maxUserID || c.MaxID < 0 || c.MaxID > maxUserID {
+		return Cursor{}, errInvalidCursor
+	}
+	if c.Sort != sort || c.Order != key.Order {
+		return Cursor{}, errInvalidCursor
+	}
+
+	switch v := c.Value.(type) {
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		if err != nil || sort != "id" {
+			return Cursor{}, errInvalidCursor
+		}
+		c.Value = n
+	case string:
+		switch sort {
+		case "id":
+			return Cursor{}, errInvalidCursor
+		case "created_at", "updated_at":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return Cursor{}, errInvalidCursor
+			}
+			c.Value = t
+		}
+	default:
+		return Cursor{}, errInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ConnectDB establishes a connection pool to the PostgreSQL database and
+// pings it; ctx bounds both.
+func ConnectDB(ctx context.Context, conf Config) (*pgxpool.Pool, error) {
+	cfg, err := poolConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	//test the connection
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return pool, nil
+}
+
+// poolConfig builds the pgx pool configuration for conf.
+func poolConfig(conf Config) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(conf.DBURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_URL: %w", err)
+	}
+	// Pool sizing and recycling; zero keeps pgx's defaults (or the URL's pool_max_conns)
+	if conf.DBMaxConns > 0 {
+		cfg.MaxConns = conf.DBMaxConns
+	}
+	if conf.DBMinConns > 0 {
+		cfg.MinConns = conf.DBMinConns
+	}
+	if conf.DBMaxConnLife > 0 {
+		cfg.MaxConnLifetime = conf.DBMaxConnLife
+	}
+	if conf.DBMaxConnIdle > 0 {
+		cfg.MaxConnIdleTime = conf.DBMaxConnIdle
+	}
+	// Set per connection at startup, so it also covers work without a request deadline
+	if conf.DBStmtTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(conf.DBStmtTimeout.Milliseconds(), 10)
+	}
+	cfg.ConnConfig.Tracer = queryTracer{}
+	// Every distinct SQL text takes a slot in each connection's cache
+	// (DB_STATEMENT_CACHE_CAPACITY, pgx default 512); see /admin/debug/sql
+	if conf.DBStmtCacheCapacity >= 0 {
+		cfg.ConnConfig.StatementCacheCapacity = conf.DBStmtCacheCapacity
+	}
+	// pgx refuses to run queries in its default cache_statement mode
+	// without a cache; 0 means preparing (unnamed) and executing every
+	// query instead
+	if conf.DBStmtCacheCapacity == 0 {
+		cfg.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	}
+	return cfg, nil
+}
+
+// isQueryCanceled reports whether Postgres cancelled the statement, e.g. on
+// statement_timeout (SQLSTATE 57014).
+func isQueryCanceled(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57014"
+}
+
+// isNumericOutOfRange reports whether err is a Postgres numeric overflow,
+// e.g. a BIGINT past its largest value (SQLSTATE 22003).
+func isNumericOutOfRange(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22003"
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation (SQLSTATE 23505).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// queryTracer is the pgx tracer for every connection. It counts queries and
+// statement prepares (cache misses, in pgx's default exec mode), records each
+// SQL text in recentSQL, and logs failed queries with the request ID of the
+// context they ran under (see logFor). Missing rows and unique violations
+// are expected outcomes, not failures, so they're not logged.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	dbQueries.Inc()
+	recentSQL.record(data.SQL)
+	return ctx
+}
+
+func (queryTracer) TracePrepareStart(ctx context.Context, _ *pgx.Conn, _ pgx.TracePrepareStartData) context.Context {
+	dbPrepares.Inc()
+	return ctx
+}
+
+func (queryTracer) TracePrepareEnd(context.Context, *pgx.Conn, pgx.TracePrepareEndData) {}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if data.Err == nil || errors.Is(data.Err, pgx.ErrNoRows) || isUniqueViolation(data.Err) {
+		return
+	}
+	logFor(ctx).Error("query failed", "error", data.Err)
+}
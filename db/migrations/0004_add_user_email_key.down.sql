@@ -0,0 +1,3 @@
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+DROP INDEX IF EXISTS idx_users_email_key;
+ALTER TABLE users DROP COLUMN IF EXISTS email_key;
@@ -0,0 +1,8 @@
+-- email keeps the address as displayed; email_key carries uniqueness
+-- (lowercased, punycode domain, optionally without +tag). Existing rows
+-- are backfilled with lower(email), which matches for ASCII domains.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_key TEXT;
+UPDATE users SET email_key = lower(email) WHERE email_key IS NULL;
+ALTER TABLE users ALTER COLUMN email_key SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_key ON users (email_key);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
@@ -0,0 +1,4 @@
+-- Soft delete: DELETE /users/:id sets deleted_at instead of removing the row.
+-- The row keeps its email_key, so the address stays reserved until the user
+-- is restored or purged.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
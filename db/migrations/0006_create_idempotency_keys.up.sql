@@ -0,0 +1,14 @@
+-- Idempotency-Key records for retried writes (see idempotency.go). Keys are
+-- scoped to the API key name (principal, '' when auth is off). status 0
+-- marks a request still in flight.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  principal    TEXT NOT NULL,
+  key          TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  status       INT NOT NULL DEFAULT 0,
+  headers      JSONB NOT NULL DEFAULT '{}'::jsonb,
+  body         BYTEA,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (principal, key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys (created_at);
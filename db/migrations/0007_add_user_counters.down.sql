@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS profile_view_count;
+ALTER TABLE users DROP COLUMN IF EXISTS login_count;
@@ -0,0 +1,4 @@
+-- Counters changed only through POST /users/:id/counters, which adds to
+-- them in place. The checks back up its refusal to go below zero.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS login_count BIGINT NOT NULL DEFAULT 0 CHECK (login_count >= 0);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_view_count BIGINT NOT NULL DEFAULT 0 CHECK (profile_view_count >= 0);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_email_lower_prefix;
+DROP INDEX IF EXISTS idx_users_name_lower_prefix;
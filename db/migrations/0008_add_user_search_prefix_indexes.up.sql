@@ -0,0 +1,5 @@
+-- Prefix indexes for ?q= when the search guard falls back to prefix
+-- matching (lower(col) LIKE 'term%'). text_pattern_ops makes them usable
+-- for LIKE whatever the database collation.
+CREATE INDEX IF NOT EXISTS idx_users_name_lower_prefix ON users (lower(name) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_lower_prefix ON users (lower(email) text_pattern_ops);
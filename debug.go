@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Test hooks for client developers, off unless ENABLE_DEBUG=true, and
+// always off when APP_ENV=production.
+//
+//	ENABLE_DEBUG     turns on the query params below on every route
+//	DEBUG_MAX_DELAY  upper bound for ?delay= (default 10s)
+
+// errInjectedFault is the error behind ?fail=db, attached for the request log.
+var errInjectedFault = errors.New("injected fault (?fail=db)")
+
+// debugHooks returns middleware for the debug query params:
+//
+//	?delay=2s     wait this long (capped at maxDelay) before handling the request
+//	?fail=<code>  skip the handler and answer with that 4xx/5xx status
+//	?fail=db      answer as if the database failed (500, logged like a real failure)
+//	?fail=timeout answer as if the request deadline passed (504)
+//
+// The wait counts against the request timeout and ends early if the request
+// is cancelled, so clients can exercise their own timeouts and retries.
+// A delay runs before a fault, so both can be combined.
+func debugHooks(maxDelay time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v := c.Query("delay"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				abortError(c, http.StatusBadRequest, gin.H{"error": "delay must be a duration like 2s"})
+				return
+			}
+			t := time.NewTimer(min(d, maxDelay))
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-c.Request.Context().Done():
+				c.Abort()
+				respondInternalError(c, c.Request.Context().Err())
+				return
+			}
+		}
+
+		switch fail := c.Query("fail"); fail {
+		case "":
+			c.Next()
+		case "db":
+			c.Abort()
+			respondInternalError(c, errInjectedFault)
+		case "timeout":
+			c.Abort()
+			respondInternalError(c, context.DeadlineExceeded)
+		default:
+			code, err := strconv.Atoi(fail)
+			if err != nil || code < 400 || code > 599 {
+				abortError(c, http.StatusBadRequest, gin.H{"error": "fail must be db, timeout or a 4xx/5xx status code"})
+				return
+			}
+			abortError(c, code, gin.H{"error": "injected fault", "code": "injected_fault"})
+		}
+	}
+}
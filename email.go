@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/net/idna"
+)
+
+// STRIP_PLUS_ADDRESSING=true makes "user+tag@x.com" count as "user@x.com" for
+// uniqueness. The address is still stored and shown as entered.
+// Stored keys are computed on write, so changing the setting on a database that
+// already has users needs a `go-rest-api -rekey-emails` run (see rekeyEmails);
+// until then lookups and duplicate checks disagree with the existing rows.
+
+// errInvalidEmailDomain is returned when the domain can't be converted to punycode.
+var errInvalidEmailDomain = errors.New("invalid email domain")
+
+// normalizeEmail returns the display form of an email address as stored in
+// users.email: trimmed and lowercased, with an internationalized domain kept
+// in its unicode form ("bücher.de", not "xn--bcher-kva.de").
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailKey returns the canonical form used for uniqueness and lookups
+// (users.email_key): lowercased, domain converted to punycode, and with
+// plus-addressing removed when stripPlus (STRIP_PLUS_ADDRESSING) is on. Two
+// addresses with the same key belong to the same account.
+func emailKey(email string, stripPlus bool) (string, error) {
+	local, domain, ok := strings.Cut(normalizeEmail(email), "@")
+	if !ok {
+		return "", errInvalidEmailDomain
+	}
+	if stripPlus {
+		local, _, _ = strings.Cut(local, "+")
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", errInvalidEmailDomain
+	}
+	return local + "@" + ascii, nil
+}
+
+// rekeyBatchSize is how many users rekeyEmails reads per query.
+const rekeyBatchSize = 1000
+
+// rekeyEmails recomputes users.email_key for every row (deleted ones too,
+// since the unique index covers them) as emailKey with stripPlus. Each
+// update commits on its own: a key that now collides with another user's,
+// e.g. a+x@ and a+y@ once plus-addressing is stripped, is logged and left
+// as it was for an operator to resolve, and the rest still go through. It
+// returns how many keys changed and how many collided.
+func rekeyEmails(ctx context.Context, db *pgxpool.Pool, stripPlus bool) (changed, conflicts int, err error) {
+	var lastID int
+	for {
+		rows, err := db.Query(ctx,
+			"SELECT id, email, email_key FROM users WHERE id > $1 ORDER BY id LIMIT $2",
+			lastID, rekeyBatchSize)
+		if err != nil {
+			return changed, conflicts, err
+		}
+		type row struct {
+			ID       int
+			Email    string
+			EmailKey string
+		}
+		batch, err := pgx.CollectRows(rows, pgx.RowToStructByPos[row])
+		if err != nil {
+			return changed, conflicts, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		lastID = batch[len(batch)-1].ID
+
+		for _, r := range batch {
+			key, err := emailKey(r.Email, stripPlus)
+			if err != nil {
+				log.Printf("⚠️ user %d: %q has no valid key, left as %q", r.ID, r.Email, r.EmailKey)
+				continue
+			}
+			if key == r.EmailKey {
+				continue
+			}
+			_, err = db.Exec(ctx, "UPDATE users SET email_key=$2 WHERE id=$1", r.ID, key)
+			switch {
+			case isUniqueViolation(err):
+				conflicts++
+				log.Printf("⚠️ user %d: key %q is taken by another user, left as %q", r.ID, key, r.EmailKey)
+			case err != nil:
+				return changed, conflicts, err
+			default:
+				changed++
+			}
+		}
+	}
+	return changed, conflicts, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestEmailKey(t *testing.T) {
+	tests := []struct {
+		email     string
+		stripPlus bool
+		want      string
+	}{
+		{"Ada@Example.com", false, "ada@example.com"},
+		{" ada@example.com ", false, "ada@example.com"},
+		{"hans@bücher.de", false, "hans@xn--bcher-kva.de"},
+		{"Hans@BÜCHER.de", false, "hans@xn--bcher-kva.de"},
+		{"hans@xn--bcher-kva.de", false, "hans@xn--bcher-kva.de"},
+		{"user@例え.jp", false, "user@xn--r8jz45g.jp"},
+		{"jürgen@example.com", false, "jürgen@example.com"}, // the local part is the mailbox's business
+		{"ada+news@example.com", false, "ada+news@example.com"},
+		{"ada+news@example.com", true, "ada@example.com"},
+		{"ada+news+promo@example.com", true, "ada@example.com"},
+		{"hans+shop@bücher.de", true, "hans@xn--bcher-kva.de"},
+	}
+	for _, tc := range tests {
+		got, err := emailKey(tc.email, tc.stripPlus)
+		if err != nil || got != tc.want {
+			t.Errorf("emailKey(%q, %v) = %q, %v, want %q", tc.email, tc.stripPlus, got, err, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"no-at-sign", "ada@exa mple.com", "ada@-example-.com"} {
+		if got, err := emailKey(bad, false); err == nil {
+			t.Errorf("emailKey(%q) = %q, want an error", bad, got)
+		}
+	}
+}
+
+// International addresses are shown as entered but unique by punycode key.
+func TestIDNEmails(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+
+	w := serve(r, "POST", "/users", `{"name":"Hans","email":"Hans@Bücher.de"}`)
+	if w.Code != 201 {
+		t.Fatalf("create = %d, want 201\n%s", w.Code, w.Body)
+	}
+	if got := jsonObject(t, w)["email"]; got != "hans@bücher.de" {
+		t.Errorf("email = %v, want the unicode form", got)
+	}
+	if w := serve(r, "POST", "/users", `{"name":"Hans","email":"hans@xn--bcher-kva.de"}`); w.Code != 409 {
+		t.Errorf("punycode spelling of a taken address = %d, want 409", w.Code)
+	}
+	for _, path := range []string{"/users/by-email/hans@xn--bcher-kva.de", "/users/by-email/HANS@B%C3%9CCHER.DE"} {
+		if w := serve(r, "GET", path, ""); w.Code != 200 {
+			t.Errorf("GET %s = %d, want 200", path, w.Code)
+		}
+	}
+}
+
+func TestPlusAddressing(t *testing.T) {
+	for _, tc := range []struct {
+		stripPlus bool
+		status    int
+	}{
+		{false, 201},
+		{true, 409},
+	} {
+		cfg := testConfig(t)
+		cfg.StripPlusAddressing = tc.stripPlus
+		r := testRouter(t, newFakeRepo(), cfg)
+
+		if w := serve(r, "POST", "/users", `{"name":"Ada","email":"ada@example.com"}`); w.Code != 201 {
+			t.Fatalf("first create = %d, want 201", w.Code)
+		}
+		w := serve(r, "POST", "/users", `{"name":"Ada","email":"Ada+promo@example.com"}`)
+		if w.Code != tc.status {
+			t.Errorf("STRIP_PLUS_ADDRESSING=%v: plus-addressed duplicate = %d, want %d", tc.stripPlus, w.Code, tc.status)
+		}
+		if tc.status == 201 && jsonObject(t, w)["email"] != "ada+promo@example.com" {
+			t.Errorf("stored email = %v, want the address as entered", jsonObject(t, w)["email"])
+		}
+
+		// Looking up any tagged variant finds the account when stripping
+		w = serve(r, "GET", "/users/by-email/ada+other@example.com", "")
+		if want := map[bool]int{false: 404, true: 200}[tc.stripPlus]; w.Code != want {
+			t.Errorf("STRIP_PLUS_ADDRESSING=%v: lookup of another tag = %d, want %d", tc.stripPlus, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader reads typed settings from the environment. A value that doesn't
+// parse is recorded in problems and the default used in its place, so
+// LoadConfig can report it along with every other bad setting; it never
+// silently falls back.
+type envReader struct {
+	problems []string
+}
+
+// Int reads an integer, falling back to def when unset.
+func (e *envReader) Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.problems = append(e.problems, fmt.Sprintf("%s must be an integer, got %q", key, v))
+		return def
+	}
+	return n
+}
+
+// Duration reads a time.Duration (e.g. "10s"), falling back to def when unset.
+func (e *envReader) Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.problems = append(e.problems, fmt.Sprintf("%s must be a duration like 10s, got %q", key, v))
+		return def
+	}
+	return d
+}
+
+// splitList splits a comma-separated setting, trimming entries and dropping blanks.
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// envList reads a comma-separated list from the environment, falling back
+// to def when unset.
+func envList(key, def string) []string {
+	if v := os.Getenv(key); v != "" {
+		return splitList(v)
+	}
+	return splitList(def)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// REQUIRE_IF_MATCH=true makes PUT and PATCH /users/:id reject requests
+// without an If-Match header (428). Off by default so existing clients keep
+// working.
+
+// userETag is the strong ETag of a user: its updated_at in microseconds
+// (Postgres' resolution), so it changes with every write and can be turned
+// back into the exact updated_at for a conditional UPDATE.
+func userETag(u User) string {
+	return `"` + strconv.FormatInt(u.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+// parseETag reverses userETag. Weak tags never match for writes.
+func parseETag(tag string) (time.Time, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return time.Time{}, false
+	}
+	us, err := strconv.ParseInt(tag[1:len(tag)-1], 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(us), true
+}
+
+// respondUser writes u with its ETag. On GET it answers 304 instead when
+// the request's If-None-Match already names that ETag.
+func respondUser(c *gin.Context, status int, u User) {
+	etag := userETag(u)
+	c.Header("ETag", etag)
+	if c.Request.Method == http.MethodGet && noneMatch(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(status, u)
+}
+
+// noneMatch reports whether an If-None-Match header matches etag
+// (weak comparison, as RFC 9110 requires for this header).
+func noneMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch reads If-Match for a conditional write. It returns the updated_at
+// the write must match (nil for none or "*"). On failure it writes the
+// error response itself and returns false: 428 when the header is required
+// but missing, 412 when it can't match any version.
+func ifMatch(c *gin.Context, required bool) (*time.Time, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	switch header {
+	case "":
+		if required {
+			respondError(c, http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+			return nil, false
+		}
+		return nil, true
+	case "*":
+		return nil, true
+	}
+	v, ok := parseETag(header)
+	if !ok {
+		respondError(c, http.StatusPreconditionFailed, gin.H{"error": "user was modified"})
+		return nil, false
+	}
+	return &v, true
+}
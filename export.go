@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Exports of GET /users as CSV or NDJSON, chosen with ?format= or the Accept
+// header, or of every match at GET /users.csv and GET /users/export
+// (NDJSON). Rows are written as they are scanned (see Each), so an export of
+// any size runs in constant memory.
+//
+// The dedicated routes run under EXPORT_TIMEOUT (default 30m) instead of
+// REQUEST_TIMEOUT_SAFE, and an unlimited read is exempt from
+// DB_STATEMENT_TIMEOUT (see Each), so a large export isn't cut off
+// mid-stream. ?format= on GET /users is still an ordinary GET and stays
+// bounded by REQUEST_TIMEOUT_SAFE; big exports belong on the dedicated routes.
+
+// exportRoutes are the dedicated export routes, which get EXPORT_TIMEOUT
+// rather than the usual request deadline (see withTimeout).
+var exportRoutes = map[string]bool{"/users.csv": true, "/users/export": true}
+
+// exportRouteKey is the gin context key a dedicated export route sets to
+// its format, overriding ?format= and Accept.
+const exportRouteKey = "export_format"
+
+// exportTypes maps each export format to its Content-Type.
+var exportTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// csvColumns is the header line of a CSV export. ?columns=all appends
+// csvExtraColumns.
+var (
+	csvColumns      = []string{"id", "name", "email", "created_at", "updated_at"}
+	csvExtraColumns = []string{"metadata", "deleted_at", "login_count", "profile_view_count"}
+)
+
+// exportFormat returns the export format the request asks for, or "" for
+// the usual JSON page. ?format= wins over Accept, where the first listed
+// type we know decides. On an unknown ?format= it writes a 400 and
+// returns false.
+func exportFormat(c *gin.Context) (string, bool) {
+	if f := c.GetString(exportRouteKey); f != "" {
+		return f, true
+	}
+	switch f := c.Query("format"); f {
+	case "json":
+		return "", true
+	case "csv", "ndjson":
+		return f, true
+	case "":
+	default:
+		respondError(c, http.StatusBadRequest, gin.H{"error": "format must be json, csv or ndjson"})
+		return "", false
+	}
+
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/csv":
+			return "csv", true
+		case "application/x-ndjson", "application/ndjson":
+			return "ndjson", true
+		case "application/json", "*/*":
+			return "", true
+		}
+	}
+	return "", true
+}
+
+// ---------------------------------------------------------------
+// GET /users.csv, GET /users/export -> every matching user in format
+// ---------------------------------------------------------------
+// Export returns the handler of a dedicated export route. It takes GET
+// /users' filters and sort, but limit and offset don't apply: the whole
+// filtered set is streamed.
+func (h *UserHandler) Export(format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(exportRouteKey, format)
+		h.List(c)
+	}
+}
+
+// exportList writes every user matching f in format. Nothing is sent until
+// the first row is scanned, so an early failure is still a normal error
+// response. A failure after that cuts the connection (see abortStream).
+func (h *UserHandler) exportList(c *gin.Context, f UserFilter, format string) {
+	all := false
+	switch columns := c.Query("columns"); {
+	case columns == "":
+	case format != "csv":
+		respondError(c, http.StatusBadRequest, gin.H{"error": "columns is only available in csv exports"})
+		return
+	case columns == "all":
+		all = true
+	default:
+		respondError(c, http.StatusBadRequest, gin.H{"error": "columns must be all"})
+		return
+	}
+
+	var write func(User) error
+	var flush func() error
+	switch format {
+	case "csv":
+		w := csv.NewWriter(c.Writer)
+		write = func(u User) error { return w.Write(csvRecord(u, all)) }
+		flush = func() error { w.Flush(); return w.Error() }
+	case "ndjson":
+		enc := json.NewEncoder(c.Writer) // Encode ends each object with "\n"
+		write = func(u User) error { return enc.Encode(u) }
+		flush = func() error { return nil }
+	}
+
+	started := false
+	start := func() error {
+		started = true
+		c.Header("Content-Type", exportTypes[format])
+		c.Header("Content-Disposition", `attachment; filename="users.`+format+`"`)
+		c.Status(http.StatusOK)
+		if format == "csv" {
+			header := csvColumns
+			if all {
+				header = slices.Concat(csvColumns, csvExtraColumns)
+			}
+			return csv.NewWriter(c.Writer).WriteAll([][]string{header})
+		}
+		return nil
+	}
+
+	n := 0
+	_, err := h.repo.Each(c, f, func(u User, _ *int) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := write(u); err != nil {
+			return err
+		}
+		if n++; n%streamFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		respondRepoError(c, err)
+		return
+	}
+	if err == nil && !started {
+		err = start() // no matches: CSV still gets its header line
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		abortStream(c, n, err)
+		return
+	}
+	c.Writer.Flush()
+}
+
+// csvRecord renders u as a CSV row in csvColumns order, followed by
+// csvExtraColumns when all is set. Metadata is a JSON object in one cell;
+// deleted_at is empty for live users. Text cells go through csvText.
+func csvRecord(u User, all bool) []string {
+	record := []string{
+		strconv.Itoa(u.ID), csvText(u.Name), csvText(u.Email),
+		u.CreatedAt.Format(time.RFC3339Nano), u.UpdatedAt.Format(time.RFC3339Nano),
+	}
+	if !all {
+		return record
+	}
+	metadata := ""
+	if len(u.Metadata) > 0 {
+		b, _ := json.Marshal(u.Metadata) // came from jsonb, so it marshals
+		metadata = string(b)
+	}
+	deletedAt := ""
+	if u.DeletedAt != nil {
+		deletedAt = u.DeletedAt.Format(time.RFC3339Nano)
+	}
+	return append(record, csvText(metadata), deletedAt,
+		strconv.FormatInt(u.LoginCount, 10), strconv.FormatInt(u.ProfileViewCount, 10))
+}
+
+// csvText makes a user-supplied value safe to open in a spreadsheet: a cell
+// starting with =, +, -, @, tab or carriage return may run as a formula, so
+// it gets a leading ' and shows as text instead.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// abortStream ends a response whose body is already partly sent by closing
+// the connection. CSV and NDJSON have no way to mark an error, and a
+// normal ending would look like a complete (if short) export; a broken
+// connection makes the client's read fail instead.
+func abortStream(c *gin.Context, items int, err error) {
+	c.Error(err)
+	logFor(c).Error("stream aborted", "method", c.Request.Method, "path", c.Request.URL.Path, "items", items, "error", err)
+	c.Writer.Flush()
+	if conn, _, herr := c.Writer.Hijack(); herr == nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+func TestCSVExport(t *testing.T) {
+	repo := seedUsers()
+	repo.users[1].Name = "=HYPERLINK(\"http://evil.test\")"
+	r := testRouter(t, repo, testConfig(t))
+
+	tests := []struct {
+		target string
+		header string
+	}{
+		{"/users.csv", "id,name,email,created_at,updated_at"},
+		{"/users?format=csv", "id,name,email,created_at,updated_at"},
+		{"/users.csv?columns=all", "id,name,email,created_at,updated_at,metadata,deleted_at,login_count,profile_view_count"},
+	}
+	for _, tc := range tests {
+		w := serve(r, "GET", tc.target, "")
+		if w.Code != 200 {
+			t.Fatalf("GET %s = %d\n%s", tc.target, w.Code, w.Body)
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+			t.Errorf("GET %s: Content-Disposition %q, want an attachment", tc.target, cd)
+		}
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(rows[0], ","); got != tc.header {
+			t.Errorf("GET %s: header %s, want %s", tc.target, got, tc.header)
+		}
+		if len(rows) != 3 || len(rows[1]) != len(rows[0]) {
+			t.Errorf("GET %s: rows %q, want 2 users under the header", tc.target, rows)
+		}
+		if rows[2][1] != `'=HYPERLINK("http://evil.test")` {
+			t.Errorf("GET %s: formula name exported as %q", tc.target, rows[2][1])
+		}
+	}
+
+	for _, target := range []string{"/users.csv?columns=metadata", "/users/export?columns=all"} {
+		if w := serve(r, "GET", target, ""); w.Code != 400 {
+			t.Errorf("GET %s = %d, want 400", target, w.Code)
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	for in, want := range map[string]string{
+		"Ada":        "Ada",
+		"":           "",
+		"=1+1":       "'=1+1",
+		"+1":         "'+1",
+		"-1":         "'-1",
+		"@SUM(A1)":   "'@SUM(A1)",
+		"\t=1+1":     "'\t=1+1",
+		"\r=1+1":     "'\r=1+1",
+		"a=1":        "a=1",
+		"ada@x.test": "ada@x.test",
+	} {
+		if got := csvText(in); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+// userFacets is the registry of facets GET /users?facets= accepts, mapping
+// each name to the SQL expression users are grouped by. The status and tags
+// facets the admin UI asked for aren't here: users have no status or tags
+// columns yet, and each becomes one entry here once they do.
+var userFacets = map[string]string{
+	"domain": "split_part(email_key, '@', 2)", // email domain, punycode form
+}
+
+// facetBuckets is how many of the largest buckets each facet reports by name.
+const facetBuckets = 20
+
+// Facet is the distribution of the filtered users over one facet.
+type Facet struct {
+	Buckets []FacetBucket `json:"buckets"` // largest first
+	Other   int           `json:"other"`   // users outside the reported buckets
+}
+
+// FacetBucket is one value of a facet and how many users have it.
+type FacetBucket struct {
+	Value *string `json:"value"` // nil for users the expression gives NULL
+	Count int     `json:"count"`
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// facetUsers returns users spread over 24 email domains: d01.test to
+// d04.test with three users each, the rest with one. Users on even
+// domains are on team "red".
+func facetUsers() []User {
+	var users []User
+	for d := 1; d <= 24; d++ {
+		n := 1
+		if d <= 4 {
+			n = 3
+		}
+		team := map[bool]string{true: "red", false: "blue"}[d%2 == 0]
+		for i := range n {
+			users = append(users, User{
+				Name:     fmt.Sprintf("User %d-%d", d, i),
+				Email:    fmt.Sprintf("u%d@d%02d.test", i, d),
+				Metadata: map[string]any{"team": team},
+			})
+		}
+	}
+	return users
+}
+
+// listFacet returns the total and the domain facet of GET target.
+func listFacet(t *testing.T, r http.Handler, target string) (total float64, facet map[string]any) {
+	t.Helper()
+	w := serve(r, "GET", target, "")
+	if w.Code != 200 {
+		t.Fatalf("GET %s = %d\n%s", target, w.Code, w.Body)
+	}
+	body := jsonObject(t, w)
+	facets, _ := body["facets"].(map[string]any)
+	facet, _ = facets["domain"].(map[string]any)
+	total, _ = body["total"].(float64)
+	return total, facet
+}
+
+// Facets cover the whole filtered set, not the page, and each bucket agrees
+// with an independent count of the same filter narrowed to that value.
+func TestFacets(t *testing.T) {
+	r := testRouter(t, newFakeRepo(facetUsers()...), testConfig(t))
+
+	for _, filter := range []string{"", "&metadata.team=red"} {
+		total, facet := listFacet(t, r, "/users?facets=domain&limit=1"+filter)
+		buckets := facet["buckets"].([]any)
+		if len(buckets) > facetBuckets {
+			t.Errorf("%d buckets, want at most %d", len(buckets), facetBuckets)
+		}
+		sum := facet["other"].(float64)
+		for _, b := range buckets {
+			b := b.(map[string]any)
+			sum += b["count"].(float64)
+
+			// The independent count: the same listing searched to the domain
+			n, _ := listFacet(t, r, "/users?limit=1&q="+url.QueryEscape("@"+b["value"].(string))+filter)
+			if n != b["count"] {
+				t.Errorf("filter %q: bucket %v counts %v, listing counts %v", filter, b["value"], b["count"], n)
+			}
+		}
+		if sum != total {
+			t.Errorf("filter %q: buckets and other add up to %v, total is %v", filter, sum, total)
+		}
+	}
+
+	// Largest first, ties by value; the 4 smallest collapse into other
+	_, facet := listFacet(t, r, "/users?facets=domain")
+	first := facet["buckets"].([]any)[0].(map[string]any)
+	if first["value"] != "d01.test" || first["count"] != 3.0 || facet["other"] != 4.0 {
+		t.Errorf("facet = %v, want d01.test (3) first and other 4", facet)
+	}
+}
+
+// Facets don't depend on which page is shown, cursor pages included.
+func TestFacetsPerPage(t *testing.T) {
+	r := testRouter(t, newFakeRepo(facetUsers()...), testConfig(t))
+	w := serve(r, "GET", "/users?facets=domain&limit=5", "")
+	first := jsonObject(t, w)
+	cursor, _ := first["next_cursor"].(string)
+	if cursor == "" {
+		t.Fatalf("no next_cursor: %v", first)
+	}
+	_, facet := listFacet(t, r, "/users?facets=domain&limit=5&cursor="+url.QueryEscape(cursor))
+	if fmt.Sprint(facet) != fmt.Sprint(first["facets"].(map[string]any)["domain"]) {
+		t.Errorf("facets differ between pages:\n%v\n%v", first["facets"], facet)
+	}
+
+	if w := serve(r, "GET", "/users?facets=status", ""); w.Code != 400 {
+		t.Errorf("unknown facet = %d, want 400", w.Code)
+	}
+}
+
+// The same agreement against Postgres, where the grouped query runs. Set
+// TEST_DB_URL to a scratch database to run it.
+func TestFacetsPostgres(t *testing.T) {
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	ctx := context.Background()
+	cfg := testConfig(t)
+	cfg.DBURL = dbURL
+	db, err := ConnectDB(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tag this run's users so other rows in the database don't count
+	run := fmt.Sprint(time.Now().UnixNano())
+	repo := NewUserRepository(db, cfg)
+	t.Cleanup(func() { db.Exec(ctx, "DELETE FROM users WHERE metadata->>'facet_run' = $1", run) })
+	for _, u := range facetUsers() {
+		u.Email = run + u.Email
+		key, _ := emailKey(u.Email, false)
+		u.Metadata["facet_run"] = run
+		if _, err := repo.Create(ctx, UserInput{Name: u.Name, Email: u.Email, EmailKey: key, Metadata: u.Metadata}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, f := range []UserFilter{
+		{Metadata: map[string]string{"facet_run": run}},
+		{Metadata: map[string]string{"facet_run": run, "team": "red"}},
+		{Metadata: map[string]string{"facet_run": run}, Query: "u1@"},
+	} {
+		facets, err := repo.Facets(ctx, f, []string{"domain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := repo.List(ctx, UserFilter{Metadata: f.Metadata, Query: f.Query, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		facet := facets["domain"]
+		sum := facet.Other
+		for _, b := range facet.Buckets {
+			sum += b.Count
+			var n int
+			err := db.QueryRow(ctx, `SELECT count(*) FROM users
+				WHERE `+notDeleted+` AND metadata->>'facet_run' = $1 AND split_part(email_key, '@', 2) = $2
+				  AND ($3 = '' OR metadata->>'team' = $3) AND ($4 = '' OR name ILIKE '%'||$4||'%' OR email ILIKE '%'||$4||'%')`,
+				run, *b.Value, f.Metadata["team"], f.Query).Scan(&n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != b.Count {
+				t.Errorf("filter %+v: bucket %s counts %d, count(*) says %d", f, *b.Value, b.Count, n)
+			}
+		}
+		if page.Total == nil || sum != *page.Total {
+			t.Errorf("filter %+v: buckets and other add up to %d, total is %v", f, sum, page.Total)
+		}
+	}
+}
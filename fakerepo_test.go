@@ -0,0 +1,488 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeRepo is an in-memory UserRepository for handler tests. It keeps the
+// contract the handlers rely on (not-found and duplicate-email errors, soft
+// deletes, If-Match versions, counters) without any SQL.
+//
+// Setting err makes every call fail with it; with failAfter > 0, Each
+// delivers that many rows first, as a query failing mid-scan would. delay
+// makes every call wait that long first, or until its context ends.
+type fakeRepo struct {
+	mu    sync.Mutex
+	users []User   // in id order
+	keys  []string // each user's email key, as users.email_key holds it
+	clock time.Time
+
+	err       error
+	failAfter int
+	delay     time.Duration
+}
+
+// newFakeRepo returns a fakeRepo holding users, whose ids and timestamps
+// are filled in as if each had been created in turn.
+func newFakeRepo(users ...User) *fakeRepo {
+	r := &fakeRepo{clock: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, u := range users {
+		key, _ := emailKey(u.Email, false)
+		r.insert(UserInput{Name: u.Name, Email: u.Email, EmailKey: key, Metadata: u.Metadata})
+	}
+	return r
+}
+
+// tick advances the fake clock, so every write gets a new updated_at (and ETag).
+func (r *fakeRepo) tick() time.Time {
+	r.clock = r.clock.Add(time.Second)
+	return r.clock
+}
+
+// wait applies delay and err. With failAfter set, err is left to Each.
+func (r *fakeRepo) wait(ctx context.Context) error {
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if r.failAfter > 0 {
+		return nil
+	}
+	return r.err
+}
+
+func (r *fakeRepo) insert(in UserInput) User {
+	now := r.tick()
+	u := User{ID: len(r.users) + 1, Name: in.Name, Email: in.Email, Metadata: in.Metadata, CreatedAt: now, UpdatedAt: now}
+	if u.Metadata == nil {
+		u.Metadata = map[string]any{}
+	}
+	r.users = append(r.users, u)
+	r.keys = append(r.keys, in.EmailKey)
+	return u
+}
+
+// find returns the user with id, live ones only unless deleted is true.
+func (r *fakeRepo) find(id int, deleted bool) *User {
+	if id < 1 || id > len(r.users) {
+		return nil
+	}
+	u := &r.users[id-1]
+	if u.DeletedAt != nil && !deleted {
+		return nil
+	}
+	return u
+}
+
+// emailError returns the error for a write that would give key to user id.
+func (r *fakeRepo) emailError(key string, id int) error {
+	for i, u := range r.users {
+		if r.keys[i] == key && u.ID != id {
+			if u.DeletedAt != nil {
+				return &DeletedEmailError{UserID: u.ID}
+			}
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+// matching returns the users f selects, in f's order, ignoring paging.
+func (r *fakeRepo) matching(f UserFilter) []User {
+	var out []User
+	for _, u := range r.users {
+		if u.DeletedAt != nil && !f.IncludeDeleted {
+			continue
+		}
+		if f.MaxID > 0 && u.ID > f.MaxID {
+			continue
+		}
+		if f.CreatedAfter != nil && u.CreatedAt.Before(*f.CreatedAfter) {
+			continue
+		}
+		if f.CreatedBefore != nil && !u.CreatedAt.Before(*f.CreatedBefore) {
+			continue
+		}
+		if f.Query != "" && !textMatch(u.Name, f.Query, f.Prefix) && !textMatch(u.Email, f.Query, f.Prefix) {
+			continue
+		}
+		if !metadataMatch(u.Metadata, f.Metadata) {
+			continue
+		}
+		out = append(out, u)
+	}
+	slices.SortStableFunc(out, func(a, b User) int {
+		for _, k := range f.Sort {
+			if c := compareColumn(a, b, k.Column); c != 0 {
+				if k.Order == "desc" {
+					return -c
+				}
+				return c
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return out
+}
+
+func textMatch(s, q string, prefix bool) bool {
+	s, q = strings.ToLower(s), strings.ToLower(q)
+	if prefix {
+		return strings.HasPrefix(s, q)
+	}
+	return strings.Contains(s, q)
+}
+
+func metadataMatch(m map[string]any, want map[string]string) bool {
+	for k, v := range want {
+		got, ok := m[k].(string)
+		if !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func compareColumn(a, b User, col string) int {
+	switch col {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "email":
+		return strings.Compare(a.Email, b.Email)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "id":
+		return cmp.Compare(a.ID, b.ID)
+	}
+	if key, ok := strings.CutPrefix(col, "metadata."); ok {
+		x, _ := a.Metadata[key].(string)
+		y, _ := b.Metadata[key].(string)
+		return strings.Compare(x, y)
+	}
+	return 0
+}
+
+// after reports whether u comes after the cursor position in f's order.
+func after(u User, f UserFilter) bool {
+	cur := cursorFor(u, f)
+	var c int
+	switch v := cur.Value.(type) {
+	case string:
+		c = strings.Compare(v, f.After.Value.(string))
+	case time.Time:
+		c = v.Compare(f.After.Value.(time.Time))
+	case int:
+		c = cmp.Compare(v, f.After.Value.(int))
+	}
+	if c == 0 {
+		c = cmp.Compare(u.ID, f.After.ID)
+	}
+	if f.Sort[0].Order == "desc" {
+		c = -c
+	}
+	return c > 0
+}
+
+func (r *fakeRepo) List(ctx context.Context, f UserFilter) (UserPage, error) {
+	var items []User
+	page, err := r.Each(ctx, f, func(u User, _ *int) error {
+		items = append(items, u)
+		return nil
+	})
+	page.Items = items
+	return page, err
+}
+
+func (r *fakeRepo) Each(ctx context.Context, f UserFilter, fn func(u User, total *int) error) (UserPage, error) {
+	r.mu.Lock()
+	if err := r.wait(ctx); err != nil {
+		r.mu.Unlock()
+		return UserPage{}, err
+	}
+	rows := r.matching(f)
+	failAfter, failErr := r.failAfter, r.err
+	r.mu.Unlock()
+
+	var page UserPage
+	if f.After != nil {
+		rows = slices.DeleteFunc(rows, func(u User) bool { return !after(u, f) })
+	} else {
+		total := len(rows)
+		if f.Limit > 0 {
+			page.Total = &total
+		}
+		rows = rows[min(f.Offset, len(rows)):]
+	}
+	if f.Limit > 0 && len(rows) > f.Limit {
+		rows, page.HasMore = rows[:f.Limit], true
+	}
+	for i, u := range rows {
+		if failAfter > 0 && i == failAfter {
+			return UserPage{}, failErr
+		}
+		if err := fn(u, page.Total); err != nil {
+			return UserPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func (r *fakeRepo) MaxID(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.users), r.wait(ctx)
+}
+
+func (r *fakeRepo) EstimateRows(ctx context.Context, f UserFilter) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return 0, err
+	}
+	return len(r.matching(f)), nil
+}
+
+func (r *fakeRepo) Facets(ctx context.Context, f UserFilter, names []string) (map[string]Facet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	// Only "domain" is registered (see userFacets): the part of the email
+	// key after the @, ranked like the SQL: largest first, then by value
+	counts := map[string]int{}
+	for _, u := range r.matching(f) {
+		_, domain, _ := strings.Cut(r.keys[u.ID-1], "@")
+		counts[domain]++
+	}
+	buckets := []FacetBucket{}
+	for v, n := range counts {
+		buckets = append(buckets, FacetBucket{Value: &v, Count: n})
+	}
+	slices.SortFunc(buckets, func(a, b FacetBucket) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(*a.Value, *b.Value))
+	})
+	facet := Facet{Buckets: buckets}
+	if len(buckets) > facetBuckets {
+		facet.Buckets = buckets[:facetBuckets]
+		for _, b := range buckets[facetBuckets:] {
+			facet.Other += b.Count
+		}
+	}
+
+	out := map[string]Facet{}
+	for _, name := range names {
+		out[name] = facet
+	}
+	return out, nil
+}
+
+func (r *fakeRepo) Get(ctx context.Context, id int, fields ...string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	u := r.find(id, false)
+	if u == nil {
+		return User{}, ErrUserNotFound
+	}
+	return *u, nil
+}
+
+func (r *fakeRepo) GetByEmail(ctx context.Context, key string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	for i, u := range r.users {
+		if r.keys[i] == key && u.DeletedAt == nil {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (r *fakeRepo) Create(ctx context.Context, in UserInput) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	if err := r.emailError(in.EmailKey, 0); err != nil {
+		return User{}, err
+	}
+	return r.insert(in), nil
+}
+
+func (r *fakeRepo) PreviewCreate(ctx context.Context, in UserInput) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	if err := r.emailError(in.EmailKey, 0); err != nil {
+		return User{}, err
+	}
+	u := r.insert(in)
+	r.users, r.keys = r.users[:len(r.users)-1], r.keys[:len(r.keys)-1]
+	return u, nil
+}
+
+func (r *fakeRepo) CreateMany(ctx context.Context, ins []UserInput, skipDuplicates bool) ([]*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	n := len(r.users)
+	out := make([]*User, len(ins))
+	for i, in := range ins {
+		if err := r.emailError(in.EmailKey, 0); err != nil {
+			if skipDuplicates {
+				continue
+			}
+			r.users, r.keys = r.users[:n], r.keys[:n] // roll back
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		u := r.insert(in)
+		out[i] = &u
+	}
+	return out, nil
+}
+
+// write applies change to live user id if its version matches ifUpdatedAt.
+func (r *fakeRepo) write(ctx context.Context, id int, ifUpdatedAt *time.Time, key string, change func(u *User)) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	u := r.find(id, false)
+	if u == nil {
+		return User{}, ErrUserNotFound
+	}
+	if ifUpdatedAt != nil && !u.UpdatedAt.Equal(*ifUpdatedAt) {
+		return User{}, ErrVersionMismatch
+	}
+	if key != "" {
+		if err := r.emailError(key, id); err != nil {
+			return User{}, err
+		}
+	}
+	change(u)
+	if key != "" {
+		r.keys[id-1] = key
+	}
+	u.UpdatedAt = r.tick()
+	return *u, nil
+}
+
+func (r *fakeRepo) Update(ctx context.Context, id int, in UserInput) (User, error) {
+	return r.write(ctx, id, in.IfUpdatedAt, in.EmailKey, func(u *User) {
+		u.Name, u.Email = in.Name, in.Email
+		if in.Metadata != nil {
+			u.Metadata = in.Metadata
+		}
+	})
+}
+
+func (r *fakeRepo) Patch(ctx context.Context, id int, p UserPatch) (User, error) {
+	key := ""
+	if p.EmailKey != nil {
+		key = *p.EmailKey
+	}
+	return r.write(ctx, id, p.IfUpdatedAt, key, func(u *User) {
+		if p.Name != nil {
+			u.Name = *p.Name
+		}
+		if p.Email != nil {
+			u.Email = *p.Email
+		}
+		if p.Metadata != nil {
+			u.Metadata = p.Metadata
+		}
+	})
+}
+
+func (r *fakeRepo) Delete(ctx context.Context, id int) error {
+	_, err := r.write(ctx, id, nil, "", func(u *User) {
+		now := r.clock
+		u.DeletedAt = &now
+	})
+	return err
+}
+
+func (r *fakeRepo) Restore(ctx context.Context, id int) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	u := r.find(id, true)
+	if u == nil || u.DeletedAt == nil {
+		return User{}, ErrUserNotFound
+	}
+	u.DeletedAt, u.UpdatedAt = nil, r.tick()
+	return *u, nil
+}
+
+func (r *fakeRepo) Touch(ctx context.Context, ids []int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		if u := r.find(id, false); u != nil {
+			u.UpdatedAt = r.tick()
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *fakeRepo) IncrementCounters(ctx context.Context, id int, deltas map[string]int64) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.wait(ctx); err != nil {
+		return User{}, err
+	}
+	u := r.find(id, false)
+	if u == nil {
+		return User{}, ErrUserNotFound
+	}
+	counters := map[string]*int64{"login_count": &u.LoginCount, "profile_view_count": &u.ProfileViewCount}
+	for _, name := range slices.Sorted(maps.Keys(deltas)) {
+		c, ok := counters[name]
+		if !ok {
+			return User{}, errors.New("unknown counter " + name)
+		}
+		// Postgres raises 22003 where int64 would wrap
+		switch d := deltas[name]; {
+		case d > 0 && *c > math.MaxInt64-d:
+			return User{}, ErrCounterOverflow
+		case *c+d < 0:
+			return User{}, ErrCounterNegative
+		}
+	}
+	for name, d := range deltas {
+		*counters[name] += d
+	}
+	return *u, nil
+}
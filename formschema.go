@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /users/schema describes the user payloads as JSON Schema (draft
+// 2020-12), for clients that build their forms from it. Like the schemas in
+// /openapi.json it is derived from the DTOs' binding and text tags (see
+// bind.go), so a form can't drift from what bindJSON enforces. Each property
+// also has its human label (the `label` tag) as title, and under x-errors
+// how a failure of each of its constraints is reported:
+//
+//	{"status": 400, "errors_key": "name"}   validation rules, as {"errors": {"name": "..."}}
+//	{"status": 422, "code": "too_long"}     text checks, as {"error": "...", "code": "too_long"}
+//
+// Strings are trimmed before they are checked, which maxLength can't
+// express; the patterns allow for it.
+
+// controlPattern matches strings with no control characters once
+// surrounding whitespace is trimmed (see checkText).
+const controlPattern = `^\s*[^\x00-\x1f\x7f-\x9f]*\s*$`
+
+// blankPattern matches strings that trim to nothing, which required and
+// min=1 reject.
+const blankPattern = `^\s*$`
+
+// userFormSchemas is the GET /users/schema document, built once.
+var userFormSchemas = gin.H{
+	"create": formSchema(reflect.TypeFor[userBody](), "Create or replace a user (POST /users, PUT /users/:id)"),
+	"update": formSchema(reflect.TypeFor[userPatchBody](), "Update some fields of a user (PATCH /users/:id); null or absent leaves a field unchanged"),
+}
+
+func serveUserSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, userFormSchemas)
+}
+
+// formSchema builds the JSON Schema of the DTO struct t. Unknown properties
+// are ignored by the server, so the schema allows them too.
+func formSchema(t reflect.Type, title string) gin.H {
+	props := gin.H{}
+	required := []string{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name := jsonName(f)
+		s, req := formField(f, name)
+		props[name] = s
+		if req {
+			required = append(required, name)
+		}
+	}
+	return gin.H{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      title,
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+// formField builds the schema of one DTO field and reports whether it is
+// required. Pointers and maps also accept null, which binds to nil.
+func formField(f reflect.StructField, name string) (gin.H, bool) {
+	s := gin.H{"title": f.Tag.Get("label")}
+	errs := gin.H{}
+	ruleErr := gin.H{"status": http.StatusBadRequest, "errors_key": name}
+
+	t := f.Type
+	nullable := t.Kind() == reflect.Pointer || t.Kind() == reflect.Map
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	typ := "object"
+	if t.Kind() == reflect.String {
+		typ = "string"
+	}
+	if nullable {
+		s["type"] = []string{typ, "null"}
+	} else {
+		s["type"] = typ
+	}
+
+	rules := parseBindingTag(f.Tag.Get("binding"))
+	rules.constrain(s, typ == "string")
+	for _, kw := range []string{"format", "minLength", "maxLength"} {
+		if _, ok := s[kw]; ok {
+			errs[kw] = ruleErr
+		}
+	}
+	if rules.Required {
+		errs["required"] = ruleErr
+	}
+	if typ == "string" && (rules.Required || rules.Min > 0) {
+		s["not"] = gin.H{"pattern": blankPattern}
+		errs["not"] = ruleErr
+	}
+
+	// text checks run after the validation rules, so a binding max on the
+	// same field is what a too-long value trips first
+	if tag, ok := f.Tag.Lookup("text"); ok && typ == "string" {
+		maxRunes, maxBytes := parseTextTag(tag)
+		s["pattern"] = controlPattern
+		errs["pattern"] = gin.H{"status": http.StatusUnprocessableEntity, "code": "control_characters"}
+		if _, ok := s["maxLength"]; !ok && maxRunes > 0 {
+			s["maxLength"] = maxRunes
+			errs["maxLength"] = gin.H{"status": http.StatusUnprocessableEntity, "code": "too_long"}
+		}
+		if maxBytes > 0 {
+			s["x-maxBytes"] = maxBytes // UTF-8 bytes; no standard keyword
+			errs["x-maxBytes"] = gin.H{"status": http.StatusUnprocessableEntity, "code": "too_long"}
+		}
+	}
+	if len(errs) > 0 {
+		s["x-errors"] = errs
+	}
+	return s, rules.Required
+}
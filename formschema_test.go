@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// validate checks v against the JSON Schema s and returns the keywords it
+// fails, as "property/keyword". It covers the keywords /users/schema uses:
+// type, properties, required, minLength, maxLength, pattern, format email,
+// not and x-maxBytes.
+func validate(s map[string]any, v any, at string) []string {
+	var failed []string
+	fail := func(kw string) { failed = append(failed, strings.TrimPrefix(at+"/"+kw, "/")) }
+
+	if typ, ok := s["type"]; ok && !slices.ContainsFunc(schemaTypes(typ), func(t string) bool { return jsonType(v) == t }) {
+		fail("type")
+		return failed
+	}
+	if obj, ok := v.(map[string]any); ok {
+		required, _ := s["required"].([]any)
+		props, _ := s["properties"].(map[string]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				failed = append(failed, name.(string)+"/required")
+			}
+		}
+		for name, ps := range props {
+			if pv, ok := obj[name]; ok {
+				failed = append(failed, validate(ps.(map[string]any), pv, name)...)
+			}
+		}
+	}
+	str, ok := v.(string)
+	if !ok {
+		return failed
+	}
+	if n, ok := s["minLength"].(float64); ok && utf8.RuneCountInString(str) < int(n) {
+		fail("minLength")
+	}
+	if n, ok := s["maxLength"].(float64); ok && utf8.RuneCountInString(str) > int(n) {
+		fail("maxLength")
+	}
+	if n, ok := s["x-maxBytes"].(float64); ok && len(str) > int(n) {
+		fail("x-maxBytes")
+	}
+	if p, ok := s["pattern"].(string); ok && !regexp.MustCompile(p).MatchString(str) {
+		fail("pattern")
+	}
+	if s["format"] == "email" {
+		if a, err := mail.ParseAddress(str); err != nil || a.Address != str {
+			fail("format")
+		}
+	}
+	if not, ok := s["not"].(map[string]any); ok && len(validate(not, v, "")) == 0 {
+		fail("not")
+	}
+	return failed
+}
+
+// schemaTypes returns the types a "type" keyword allows.
+func schemaTypes(typ any) []string {
+	if t, ok := typ.(string); ok {
+		return []string{t}
+	}
+	var types []string
+	for _, t := range typ.([]any) {
+		types = append(types, t.(string))
+	}
+	return types
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+// The served schema and the server agree on which payloads are valid, and
+// x-errors names the status (and code) the server actually answers with.
+func TestFormSchemaAgreement(t *testing.T) {
+	cfg := testConfig(t)
+	w := serve(testRouter(t, seedUsers(), cfg), "GET", "/users/schema", "")
+	if w.Code != 200 {
+		t.Fatalf("GET /users/schema = %d", w.Code)
+	}
+	schemas := jsonObject(t, w)
+
+	long := strings.Repeat("é", 200)
+	tests := []struct {
+		schema, method, target string
+		payloads               []string
+	}{
+		{"create", "POST", "/users", []string{
+			`{"name":"New","email":"new@example.com"}`,
+			`{"name":"New","email":"new@example.com","metadata":{"team":"red"}}`,
+			`{"name":"New","email":"new@example.com","metadata":null}`,
+			`{"name":"New","email":"new@example.com","unknown":1}`,
+			`{"name":"` + long + `","email":"new@example.com"}`,
+			`{"name":"` + long + `é","email":"new@example.com"}`,
+			`{"name":"` + strings.Repeat("😀", 200) + `","email":"new@example.com"}`,
+			`{"email":"new@example.com"}`,
+			`{"name":"New"}`,
+			`{"name":"","email":"new@example.com"}`,
+			`{"name":"   ","email":"new@example.com"}`,
+			`{"name":null,"email":"new@example.com"}`,
+			`{"name":5,"email":"new@example.com"}`,
+			`{"name":"New\u0007","email":"new@example.com"}`,
+			`{"name":"New\u009b","email":"new@example.com"}`,
+			`{"name":"New","email":"not-an-email"}`,
+			`{"name":"New","email":"new@example.com","metadata":"red"}`,
+			`{"name":"New","email":"new@example.com","metadata":[]}`,
+		}},
+		{"update", "PATCH", "/users/1", []string{
+			`{"name":"Ada King"}`,
+			`{"name":null,"metadata":{"team":"red"}}`,
+			`{"email":"ada.king@example.com"}`,
+			`{"name":"` + long + `"}`,
+			`{"name":"` + long + `é"}`,
+			`{"name":""}`,
+			`{"name":"  "}`,
+			`{"name":"Ada\u0000"}`,
+			`{"email":"ada"}`,
+			`{"email":7}`,
+			`{"metadata":"red"}`,
+		}},
+	}
+	for _, tc := range tests {
+		schema := schemas[tc.schema].(map[string]any)
+		for _, payload := range tc.payloads {
+			var v any
+			if err := json.Unmarshal([]byte(payload), &v); err != nil {
+				t.Fatal(err)
+			}
+			failed := validate(schema, v, "")
+			w := serve(testRouter(t, seedUsers(), cfg), tc.method, tc.target, payload)
+			accepted := w.Code < 300
+			if accepted != (len(failed) == 0) {
+				t.Errorf("%s %s: server %d, schema fails %v", tc.method, payload, w.Code, failed)
+				continue
+			}
+			if accepted {
+				continue
+			}
+
+			// Decode errors (type) aren't described; anything else is answered
+			// as one of the failed keywords' x-errors says
+			body := jsonObject(t, w)
+			reported := slices.ContainsFunc(failed, func(f string) bool {
+				prop, kw, _ := strings.Cut(f, "/")
+				if kw == "type" {
+					return true
+				}
+				props := schema["properties"].(map[string]any)
+				errs, _ := props[prop].(map[string]any)["x-errors"].(map[string]any)
+				e, _ := errs[kw].(map[string]any)
+				if e == nil || e["status"] != float64(w.Code) {
+					return false
+				}
+				if code, ok := e["code"]; ok {
+					return body["code"] == code
+				}
+				fields, _ := body["errors"].(map[string]any)
+				return fields[e["errors_key"].(string)] != nil
+			})
+			if !reported {
+				t.Errorf("%s %s: server %d %v, not as x-errors says for %v", tc.method, payload, w.Code, body, failed)
+			}
+		}
+	}
+}
@@ -0,0 +1,838 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandler serves the /users endpoints on top of a UserRepository.
+type UserHandler struct {
+	repo UserRepository
+	cfg  Config
+}
+
+// NewUserHandler returns a UserHandler backed by repo, with the limits and
+// switches in cfg.
+func NewUserHandler(repo UserRepository, cfg Config) *UserHandler {
+	return &UserHandler{repo: repo, cfg: cfg}
+}
+
+// userBody is the JSON payload for POST and PUT.
+type userBody struct {
+	Name     string         `json:"name" binding:"required,max=200" text:"200,800" label:"Name"`
+	Email    string         `json:"email" binding:"required,email" text:"254,254" label:"Email address"`
+	Metadata map[string]any `json:"metadata" label:"Metadata"`
+}
+
+// userPatchBody is the JSON payload for PATCH. Pointer fields tell
+// "not provided" (nil) apart from "set to empty".
+type userPatchBody struct {
+	Name     *string        `json:"name" binding:"omitnil,min=1,max=200" text:"200,800" label:"Name"`
+	Email    *string        `json:"email" binding:"omitnil,email" text:"254,254" label:"Email address"`
+	Metadata map[string]any `json:"metadata" label:"Metadata"`
+}
+
+// bindUserBody parses and validates a create/update payload.
+// It writes the error response itself and returns false on failure.
+func (h *UserHandler) bindUserBody(c *gin.Context) (UserInput, bool) {
+	var input userBody
+	// Bind JSON body into input struct (see bind.go for text checks)
+	if !bindJSON(c, &input) {
+		return UserInput{}, false
+	}
+	in, errBody := input.toInput(h.cfg)
+	if errBody != nil {
+		respondError(c, http.StatusBadRequest, errBody)
+		return UserInput{}, false
+	}
+	return in, true
+}
+
+// toInput runs the checks that binding tags can't express and builds the
+// repository input. It returns a 400 error body on failure.
+func (b userBody) toInput(cfg Config) (UserInput, gin.H) {
+	if err := validateMetadata(b.Metadata, cfg); err != nil {
+		return UserInput{}, gin.H{"error": err.Error()}
+	}
+	key, err := emailKey(b.Email, cfg.StripPlusAddressing)
+	if err != nil {
+		return UserInput{}, gin.H{"errors": gin.H{"email": "must be a valid email address"}}
+	}
+	return UserInput{Name: b.Name, Email: normalizeEmail(b.Email), EmailKey: key, Metadata: b.Metadata}, nil
+}
+
+// respondRepoError maps repository errors to responses.
+func respondRepoError(c *gin.Context, err error) {
+	var deleted *DeletedEmailError
+	switch {
+	case errors.As(err, &deleted):
+		// Point the caller at the restore endpoint instead of a dead end
+		respondError(c, http.StatusConflict, gin.H{
+			"error":   "email belongs to a deleted user",
+			"user_id": deleted.UserID,
+			"hint":    fmt.Sprintf("an admin can restore the user with POST /admin/users/%d/restore", deleted.UserID),
+		})
+	case errors.Is(err, ErrUserNotFound):
+		respondError(c, http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, ErrEmailTaken):
+		// Duplicate email is the caller's mistake, not ours
+		respondError(c, http.StatusConflict, gin.H{"error": "email already in use"})
+	case errors.Is(err, ErrVersionMismatch):
+		respondError(c, http.StatusPreconditionFailed, gin.H{"error": "user was modified"})
+	case errors.Is(err, ErrCounterNegative):
+		respondError(c, http.StatusConflict, gin.H{"error": "counter would go below zero", "code": "counter_negative"})
+	case errors.Is(err, ErrCounterOverflow):
+		respondError(c, http.StatusConflict, gin.H{"error": "counter would overflow", "code": "counter_overflow"})
+	default:
+		respondInternalError(c, err)
+	}
+}
+
+// ------------------------------
+// GET /users -> list all users
+// ------------------------------
+func (h *UserHandler) List(c *gin.Context) {
+	// --- Parse query params ---
+	limit := 10
+	offset := 0
+	q := c.Query("q") // search term
+	sortSpec := c.DefaultQuery("sort", "id")
+	order := c.DefaultQuery("order", "asc")
+
+	// Validate limit (default 10, max 100)
+	if l := c.Query("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+
+	// Validate offset
+	if o := c.Query("offset"); o != "" {
+		if n, err := strconv.Atoi(o); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+
+	// Validate order (the direction of sort columns without a "-")
+	if order != "asc" && order != "desc" {
+		order = "asc"
+	}
+
+	// Validate sort: e.g. name,-created_at (see parseSort)
+	sortKeys, err := parseSort(sortSpec, order, h.cfg.MetadataSortKeys)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Cursors hold one sort value, and metadata values may be NULL
+	keyset := len(sortKeys) == 1 && !strings.HasPrefix(sortKeys[0].Column, "metadata.")
+
+	// ?metadata.<key>=<value> filters on a top-level metadata key
+	metadata := map[string]string{}
+	for k, vs := range c.Request.URL.Query() {
+		if key, ok := strings.CutPrefix(k, "metadata."); ok && key != "" {
+			metadata[key] = vs[0]
+		}
+	}
+
+	// ?include_deleted=true also lists soft-deleted users (admins only)
+	includeDeleted := c.Query("include_deleted") == "true"
+	if includeDeleted && !isAdmin(c, h.cfg.AdminAPIKey) {
+		respondError(c, http.StatusForbidden, gin.H{"error": "include_deleted requires the admin API key"})
+		return
+	}
+
+	// ?created_after= / ?created_before= bound created_at to [after, before).
+	// Each takes an RFC3339 timestamp or a YYYY-MM-DD date (midnight UTC).
+	var created [2]*time.Time
+	for i, name := range []string{"created_after", "created_before"} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, v)
+		}
+		if err != nil {
+			respondError(c, http.StatusBadRequest, gin.H{"error": name + " must be an RFC3339 timestamp or a YYYY-MM-DD date"})
+			return
+		}
+		created[i] = &t
+	}
+
+	// ?cursor= switches to keyset pagination, which can't be mixed with offset
+	// and only works on plain columns (metadata values may be NULL)
+	filter := UserFilter{
+		Query:          q,
+		Metadata:       metadata,
+		CreatedAfter:   created[0],
+		CreatedBefore:  created[1],
+		Sort:           sortKeys,
+		Limit:          limit,
+		Offset:         offset,
+		IncludeDeleted: includeDeleted,
+	}
+	// ?format=csv|ndjson (or Accept) exports every match instead of a page
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+
+	// ?facets=a,b adds bucket counts over the whole filtered set
+	facetNames := splitList(c.Query("facets"))
+	for _, name := range facetNames {
+		if _, ok := userFacets[name]; !ok {
+			respondError(c, http.StatusBadRequest, gin.H{"error": "unknown facet: " + name})
+			return
+		}
+	}
+
+	// ?item_cursors=true gives every item the cursor that continues after
+	// it, so a client can resume from any row it shows, not just the last
+	itemCursors := c.Query("item_cursors") == "true"
+	if itemCursors && !keyset {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "item_cursors needs a single sort column, not metadata"})
+		return
+	}
+
+	// ?fields=id,name fetches and returns only those fields (see parseFields)
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+	filter.Fields = fields
+
+	if cur := c.Query("cursor"); cur != "" {
+		if c.Query("offset") != "" {
+			respondError(c, http.StatusBadRequest, gin.H{"error": "cursor and offset cannot be combined"})
+			return
+		}
+		if !keyset {
+			respondError(c, http.StatusBadRequest, gin.H{"error": "cursor pagination needs a single sort column, not metadata"})
+			return
+		}
+		after, err := decodeCursor(cur, sortKeys[0])
+		if err != nil {
+			respondError(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.After = &after
+		filter.MaxID = after.MaxID
+	} else if c.Query("snapshot") == "true" {
+		// ?snapshot=true pins the listing to the users that exist now: the
+		// highest id rides along in every next_cursor, so later pages skip
+		// users created since. Changes to existing users still show, so a
+		// user whose sort value changes can still move between pages.
+		if c.Query("offset") != "" || !keyset {
+			respondError(c, http.StatusBadRequest, gin.H{"error": "snapshot needs cursor pagination: no offset, and a single sort column that isn't metadata"})
+			return
+		}
+		maxID, err := h.repo.MaxID(c)
+		if err != nil {
+			respondRepoError(c, err)
+			return
+		}
+		filter.MaxID = max(maxID, 1) // 0 would mean no bound
+	}
+
+	// Expensive searches are rejected or narrowed to prefix matching (see
+	// search.go); the header tells streamed responses too
+	warnings, ok := guardSearch(c, h.repo, h.cfg, &filter)
+	if !ok {
+		return
+	}
+	if filter.Prefix {
+		c.Header("X-Search-Mode", "prefix")
+	}
+
+	// Exports stream from the rows as they're scanned (see export.go).
+	// Without an explicit limit they cover the whole filtered set.
+	if format != "" {
+		if len(facetNames) > 0 || itemCursors || fields != nil {
+			respondError(c, http.StatusBadRequest, gin.H{"error": "facets, item_cursors and fields are not available in " + format + " exports"})
+			return
+		}
+		if c.Query("limit") == "" || c.GetString(exportRouteKey) != "" {
+			filter.Limit = 0
+		}
+		if c.GetString(exportRouteKey) != "" {
+			filter.Offset = 0
+		}
+		h.exportList(c, filter, format)
+		return
+	}
+
+	// Large pages may be streamed instead of buffered (facets, item cursors
+	// and fields need the buffered envelope, so they opt out)
+	if len(facetNames) == 0 && !itemCursors && fields == nil && wantsStream(c, limit, h.cfg.StreamThreshold) {
+		h.streamList(c, filter)
+		return
+	}
+
+	// --- Execute query ---
+	// The repository reads every row before we write anything, so a failure
+	// half-way through produces one error response rather than a partial body.
+	page, err := h.repo.List(c, filter)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	// Facets ignore pagination, so they're the same for every page
+	var facets map[string]Facet
+	if len(facetNames) > 0 {
+		facets, err = h.repo.Facets(c, filter, facetNames)
+		if err != nil {
+			respondRepoError(c, err)
+			return
+		}
+	}
+
+	// next_cursor continues after the last item (null on the last page)
+	var nextCursor *string
+	if page.HasMore && keyset {
+		s := encodeCursor(cursorFor(page.Items[len(page.Items)-1], filter))
+		nextCursor = &s
+	}
+
+	items := make([]listedUser, len(page.Items))
+	for i, u := range page.Items {
+		items[i].User, items[i].fields = u, fields
+		if itemCursors {
+			items[i].Cursor = encodeCursor(cursorFor(u, filter))
+		}
+	}
+
+	// --- Return response with metadata ---
+	resp := userList{
+		ListResponse:  newList(items),
+		NextCursor:    nextCursor,
+		Sort:          sortSpec,
+		SortSpec:      sortKeys,
+		Query:         q,
+		CreatedAfter:  created[0],
+		CreatedBefore: created[1],
+		Facets:        facets,
+		Warnings:      warnings,
+	}
+	resp.Limit, resp.Order = limit, order
+
+	// Offset mode also reports the total and offset links (null at the edges);
+	// keyset mode has neither.
+	if filter.After == nil {
+		resp.Total, resp.Offset = page.Total, &offset
+		if page.HasMore {
+			n := offset + limit
+			resp.NextOffset = &n
+		}
+		if offset > 0 {
+			p := max(offset-limit, 0)
+			resp.PrevOffset = &p
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// userList is the GET /users response.
+type userList struct {
+	ListResponse[listedUser]
+	NextCursor    *string          `json:"next_cursor"` // null on the last page
+	Sort          string           `json:"sort"`
+	SortSpec      []SortKey        `json:"sort_spec"` // sort as applied; id breaks remaining ties
+	Query         string           `json:"query"`
+	CreatedAfter  *time.Time       `json:"created_after"`
+	CreatedBefore *time.Time       `json:"created_before"`
+	NextOffset    *int             `json:"next_offset"` // offset mode only
+	PrevOffset    *int             `json:"prev_offset"` // offset mode only
+	Facets        map[string]Facet `json:"facets,omitempty"`
+	Warnings      []string         `json:"warnings,omitempty"`
+}
+
+// listedUser is a user in a GET /users page. With ?item_cursors=true it
+// carries the cursor that continues the listing right after it.
+type listedUser struct {
+	User
+	Cursor string `json:"cursor,omitempty"`
+
+	fields []string // ?fields= projection; nil for all of them
+}
+
+// MarshalJSON encodes the user as usual, or only its selected fields.
+func (u listedUser) MarshalJSON() ([]byte, error) {
+	if u.fields == nil {
+		type plain listedUser // no MarshalJSON, so no recursion
+		return json.Marshal(plain(u))
+	}
+	obj := projectUser(u.User, u.fields)
+	if u.Cursor != "" {
+		obj["cursor"] = u.Cursor
+	}
+	return json.Marshal(obj)
+}
+
+// parseFields parses ?fields=, a comma-separated list of User JSON fields
+// to return. It returns nil without the parameter. On an unknown name it
+// writes a 400 listing the valid ones and returns false.
+func parseFields(c *gin.Context) ([]string, bool) {
+	fields := splitList(c.Query("fields"))
+	for _, f := range fields {
+		if !slices.Contains(userFields, f) {
+			respondError(c, http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("unknown field: %s (valid fields: %s)", f, strings.Join(userFields, ", ")),
+			})
+			return nil, false
+		}
+	}
+	return fields, true
+}
+
+// projectUser returns u as a JSON object with only the given fields, plus
+// id. Like User's own encoding, it leaves deleted_at out for live users.
+func projectUser(u User, fields []string) gin.H {
+	obj := gin.H{"id": u.ID}
+	for _, f := range fields {
+		switch f {
+		case "name":
+			obj[f] = u.Name
+		case "email":
+			obj[f] = u.Email
+		case "metadata":
+			obj[f] = u.Metadata
+		case "created_at":
+			obj[f] = u.CreatedAt
+		case "updated_at":
+			obj[f] = u.UpdatedAt
+		case "deleted_at":
+			if u.DeletedAt != nil {
+				obj[f] = u.DeletedAt
+			}
+		case "login_count":
+			obj[f] = u.LoginCount
+		case "profile_view_count":
+			obj[f] = u.ProfileViewCount
+		}
+	}
+	return obj
+}
+
+// parseSort parses ?sort=, a comma-separated list of columns applied in
+// order. A "-" prefix sorts that column descending; others go in the
+// ?order= direction (order). Metadata keys must be in metadataSortKeys
+// (METADATA_SORT_KEYS). Only these validated names ever reach ORDER BY,
+// never raw input.
+func parseSort(spec, order string, metadataSortKeys map[string]bool) ([]SortKey, error) {
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, term := range splitList(spec) {
+		dir := order
+		if col, ok := strings.CutPrefix(term, "-"); ok {
+			term, dir = col, "desc"
+		}
+		key, isMetadata := strings.CutPrefix(term, "metadata.")
+		if !sortColumns[term] && !(isMetadata && metadataSortKeys[key]) {
+			return nil, fmt.Errorf("invalid sort column: %s", term)
+		}
+		if seen[term] {
+			return nil, fmt.Errorf("sort column listed twice: %s", term)
+		}
+		seen[term] = true
+		keys = append(keys, SortKey{Column: term, Order: dir})
+	}
+	if len(keys) == 0 {
+		keys = []SortKey{{Column: "id", Order: order}}
+	}
+	return keys, nil
+}
+
+// wantsStream reports whether a listing of limit items should be streamed:
+// when limit is above threshold (STREAM_THRESHOLD, 0 disables), or when the
+// client asks with "Prefer: streaming".
+func wantsStream(c *gin.Context, limit, threshold int) bool {
+	if threshold > 0 && limit > threshold {
+		return true
+	}
+	for _, p := range strings.Split(c.GetHeader("Prefer"), ",") {
+		if strings.TrimSpace(p) == "streaming" {
+			return true
+		}
+	}
+	return false
+}
+
+// streamList writes a page as a bare JSON array, encoding each user as it is
+// scanned (see arrayStream). Metadata moves to headers: X-Total-Count and a
+// Link with rel="next"/"prev" in offset mode. In keyset mode the next cursor
+// is only known at the end, so it's sent as the X-Next-Cursor trailer.
+func (h *UserHandler) streamList(c *gin.Context, f UserFilter) {
+	stream := newArrayStream(c, "X-Next-Cursor")
+	headersSent := false
+	sendHeaders := func(total *int) {
+		headersSent = true
+		c.Header("Preference-Applied", "streaming")
+		var links []string
+		if total != nil {
+			c.Header("X-Total-Count", strconv.Itoa(*total))
+			if f.Offset+f.Limit < *total {
+				links = append(links, `<`+pageURL(c, "offset", strconv.Itoa(f.Offset+f.Limit))+`>; rel="next"`)
+			}
+		}
+		if f.After == nil && f.Offset > 0 {
+			links = append(links, `<`+pageURL(c, "offset", strconv.Itoa(max(f.Offset-f.Limit, 0)))+`>; rel="prev"`)
+		}
+		if len(links) > 0 {
+			c.Header("Link", strings.Join(links, ", "))
+		}
+	}
+
+	var last User
+	page, err := h.repo.Each(c, f, func(u User, total *int) error {
+		if !headersSent {
+			sendHeaders(total)
+		}
+		last = u
+		return stream.Write(u)
+	})
+
+	// Nothing written yet: a plain error response is still possible
+	if err != nil && !stream.Started() {
+		respondRepoError(c, err)
+		return
+	}
+	if err == nil && !headersSent {
+		sendHeaders(page.Total) // empty page
+	}
+	if err == nil && page.HasMore && f.After != nil {
+		c.Writer.Header().Set("X-Next-Cursor", encodeCursor(cursorFor(last, f)))
+	}
+	stream.Close(err)
+}
+
+// pageURL returns the current request URL with one query param replaced.
+func pageURL(c *gin.Context, key, value string) string {
+	u := *c.Request.URL
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// --------------------------------
+// GET /users/:id -> get user by ID
+// --------------------------------
+func (h *UserHandler) Get(c *gin.Context) {
+	id, ok := userID(c) // get id from URL path
+	if !ok {
+		return
+	}
+
+	// ?fields=id,name fetches and returns only those fields
+	fields, ok := parseFields(c)
+	if !ok {
+		return
+	}
+
+	// Only a missing row is a 404; anything else is a real DB failure
+	u, err := h.repo.Get(c, id, fields...)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	// A partial user has no ETag: it isn't the representation the ETag
+	// names, so it can't be revalidated or written back with If-Match
+	if fields != nil {
+		c.JSON(http.StatusOK, projectUser(u, fields))
+		return
+	}
+
+	// Respond with single user object (304 if the client's copy is current)
+	respondUser(c, http.StatusOK, u)
+}
+
+// ---------------------------------------------
+// GET /users/by-email/:email -> get user by email
+// ---------------------------------------------
+func (h *UserHandler) GetByEmail(c *gin.Context) {
+	email, err := pathValue(c, "email")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	}
+
+	key, err := emailKey(email, h.cfg.StripPlusAddressing)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "invalid email"})
+		return
+	}
+
+	u, err := h.repo.GetByEmail(c, key)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	respondUser(c, http.StatusOK, u)
+}
+
+// -------------------------------
+// POST /users -> create new user
+// -------------------------------
+// With ?preview=true the insert runs in a transaction that is rolled back,
+// and the would-be row comes back as {"preview": true, "user": {...}}.
+// Its id is only provisional: the sequence value is used up by the preview,
+// so the real create gets a later id and ids have gaps.
+func (h *UserHandler) Create(c *gin.Context) {
+	input, ok := h.bindUserBody(c)
+	if !ok {
+		return
+	}
+
+	if c.Query("preview") == "true" {
+		u, err := h.repo.PreviewCreate(c, input)
+		if err != nil {
+			respondRepoError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"preview": true, "user": u})
+		return
+	}
+
+	// Insert user and queue its webhook
+	u, err := h.repo.Create(c, input)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	// Respond with the created user
+	respondUser(c, http.StatusCreated, u)
+}
+
+// maxBatchUsers caps POST /users/batch.
+const maxBatchUsers = 500
+
+// -------------------------------------------------------------
+// POST /users/batch -> create many users in one transaction
+// -------------------------------------------------------------
+// By default any invalid row or duplicate email rejects the whole batch, and
+// the error names the row's "index". With ?partial=true bad rows are skipped
+// and every row gets its own result.
+func (h *UserHandler) CreateBatch(c *gin.Context) {
+	partial := c.Query("partial") == "true"
+
+	var bodies []userBody
+	if !decodeJSON(c, &bodies) {
+		return
+	}
+	if len(bodies) == 0 || len(bodies) > maxBatchUsers {
+		respondError(c, http.StatusBadRequest, gin.H{"error": fmt.Sprintf("batch must contain 1 to %d users", maxBatchUsers)})
+		return
+	}
+
+	// Validate every row before touching the DB. index maps each entry of
+	// inputs back to its position in the request.
+	results := make([]gin.H, len(bodies))
+	inputs := make([]UserInput, 0, len(bodies))
+	index := make([]int, 0, len(bodies))
+	for i := range bodies {
+		status, errBody := validateBody(&bodies[i])
+		var in UserInput
+		if status == 0 {
+			if in, errBody = bodies[i].toInput(h.cfg); errBody != nil {
+				status = http.StatusBadRequest
+			}
+		}
+		if status != 0 {
+			errBody["index"] = i
+			if !partial {
+				respondError(c, status, errBody)
+				return
+			}
+			errBody["status"] = status
+			results[i] = errBody
+			continue
+		}
+		inputs = append(inputs, in)
+		index = append(index, i)
+	}
+
+	users, err := h.repo.CreateMany(c, inputs, partial)
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) && errors.Is(batchErr.Err, ErrEmailTaken) {
+		respondError(c, http.StatusConflict, gin.H{"error": "email already in use", "index": index[batchErr.Index]})
+		return
+	}
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	if !partial {
+		c.JSON(http.StatusCreated, newList(users))
+		return
+	}
+	created := 0
+	for j, u := range users {
+		i := index[j]
+		if u == nil {
+			results[i] = gin.H{"index": i, "status": http.StatusConflict, "error": "email already in use"}
+			continue
+		}
+		results[i] = gin.H{"index": i, "status": http.StatusCreated, "user": u}
+		created++
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results, "created": created, "failed": len(bodies) - created})
+}
+
+// ----------------------------------
+// PUT /users/:id -> update user info
+// ----------------------------------
+func (h *UserHandler) Update(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+	// If-Match: only overwrite the version the client has seen
+	version, ok := ifMatch(c, h.cfg.RequireIfMatch)
+	if !ok {
+		return
+	}
+	input, ok := h.bindUserBody(c)
+	if !ok {
+		return
+	}
+	input.IfUpdatedAt = version
+
+	// Update user and return updated row (metadata is kept when omitted)
+	u, err := h.repo.Update(c, id, input)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	respondUser(c, http.StatusOK, u)
+}
+
+// ------------------------------------------------
+// PATCH /users/:id -> update only the given fields
+// ------------------------------------------------
+func (h *UserHandler) Patch(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatch(c, h.cfg.RequireIfMatch)
+	if !ok {
+		return
+	}
+
+	var input userPatchBody
+	if !bindJSON(c, &input) {
+		return
+	}
+	if err := validateMetadata(input.Metadata, h.cfg); err != nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == nil && input.Email == nil && input.Metadata == nil {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "no updatable fields provided"})
+		return
+	}
+	patch := UserPatch{Name: input.Name, Metadata: input.Metadata, IfUpdatedAt: version}
+	if input.Email != nil {
+		key, err := emailKey(*input.Email, h.cfg.StripPlusAddressing)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, gin.H{"errors": gin.H{"email": "must be a valid email address"}})
+			return
+		}
+		email := normalizeEmail(*input.Email)
+		patch.Email, patch.EmailKey = &email, &key
+	}
+
+	u, err := h.repo.Patch(c, id, patch)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	respondUser(c, http.StatusOK, u)
+}
+
+// ---------------------------------------
+// DELETE /users/:id -> soft-delete a user
+// ---------------------------------------
+// Deletes are always soft: POST /admin/users/:id/restore is the undo. There is no
+// hard-delete mode yet, so no delete-quarantine mode for deployments that
+// would turn soft delete off; adding both is a product decision still
+// waiting on the requester.
+func (h *UserHandler) Delete(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	// If no live row matched, the user doesn’t exist or is already deleted
+	if err := h.repo.Delete(c, id); err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	// Respond with confirmation
+	c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
+}
+
+// ------------------------------------------------------------
+// POST /users/touch -> bump updated_at without changing data
+// ------------------------------------------------------------
+func (h *UserHandler) Touch(c *gin.Context) {
+	// At most MAX_BATCH_IDS ids per call, all positive
+	var input struct {
+		IDs []int `json:"ids" binding:"required,min=1,dive,gt=0"`
+	}
+	if !bindJSON(c, &input) {
+		return
+	}
+	ids, ok := batchIDs(c, "ids", input.IDs, h.cfg.MaxBatchIDs)
+	if !ok {
+		return
+	}
+
+	n, err := h.repo.Touch(c, ids)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"touched": n})
+}
+
+// ---------------------------------------------------------
+// POST /admin/users/:id/restore -> undo a soft delete
+// ---------------------------------------------------------
+// Admin only: a write key may delete, but bringing a user back is an
+// operator's call.
+func (h *UserHandler) Restore(c *gin.Context) {
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	// 404 unless the user exists and is deleted
+	u, err := h.repo.Restore(c, id)
+	if err != nil {
+		respondRepoError(c, err)
+		return
+	}
+
+	respondUser(c, http.StatusOK, u)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// seedUsers are the users every handler case starts with (ids 1 and 2).
+func seedUsers() *fakeRepo {
+	return newFakeRepo(
+		User{Name: "Ada Lovelace", Email: "ada@example.com", Metadata: map[string]any{"team": "math"}},
+		User{Name: "Grace Hopper", Email: "grace@example.com", Metadata: map[string]any{"team": "navy"}},
+	)
+}
+
+func TestUserHandlers(t *testing.T) {
+	tests := []struct {
+		name                 string
+		method, target, body string
+		header               []string
+		setup                func(r *fakeRepo) // changes the seeded repo first
+
+		status int
+		want   map[string]any // top-level fields the JSON body must have
+		items  int            // length of "items", for list responses
+	}{
+		// GET /users
+		{name: "list", method: "GET", target: "/users",
+			status: 200, want: map[string]any{"total": 2.0, "limit": 10.0, "offset": 0.0, "order": "asc", "next_offset": nil}, items: 2},
+		{name: "list page", method: "GET", target: "/users?limit=1",
+			status: 200, want: map[string]any{"total": 2.0, "next_offset": 1.0}, items: 1},
+		{name: "list search", method: "GET", target: "/users?q=grace",
+			status: 200, want: map[string]any{"total": 1.0, "query": "grace"}, items: 1},
+		{name: "list metadata filter", method: "GET", target: "/users?metadata.team=math",
+			status: 200, want: map[string]any{"total": 1.0}, items: 1},
+		{name: "list bad sort", method: "GET", target: "/users?sort=password",
+			status: 400, want: map[string]any{"error": "invalid sort column: password"}},
+		{name: "list bad date", method: "GET", target: "/users?created_after=yesterday",
+			status: 400},
+		{name: "list hides deleted", method: "GET", target: "/users",
+			setup:  func(r *fakeRepo) { r.Delete(context.Background(), 1) },
+			status: 200, want: map[string]any{"total": 1.0}, items: 1},
+		{name: "list deleted needs admin", method: "GET", target: "/users?include_deleted=true",
+			status: 403},
+		{name: "list repository failure", method: "GET", target: "/users",
+			setup:  func(r *fakeRepo) { r.err = errors.New("connection refused") },
+			status: 500, want: map[string]any{"error": "internal server error"}},
+
+		// GET /users/:id
+		{name: "get", method: "GET", target: "/users/1",
+			status: 200, want: map[string]any{"id": 1.0, "name": "Ada Lovelace", "email": "ada@example.com", "login_count": 0.0}},
+		{name: "get fields", method: "GET", target: "/users/2?fields=name",
+			status: 200, want: map[string]any{"id": 2.0, "name": "Grace Hopper"}},
+		{name: "get missing", method: "GET", target: "/users/99",
+			status: 404, want: map[string]any{"error": "user not found"}},
+		{name: "get beyond int4", method: "GET", target: "/users/99999999999",
+			status: 404, want: map[string]any{"error": "user not found"}},
+		{name: "get bad id", method: "GET", target: "/users/abc",
+			status: 400},
+		{name: "get deleted", method: "GET", target: "/users/1",
+			setup:  func(r *fakeRepo) { r.Delete(context.Background(), 1) },
+			status: 404},
+
+		// GET /users/by-email/:email
+		{name: "get by email", method: "GET", target: "/users/by-email/GRACE@example.com",
+			status: 200, want: map[string]any{"id": 2.0}},
+		{name: "get by email missing", method: "GET", target: "/users/by-email/nobody@example.com",
+			status: 404},
+
+		// POST /users
+		{name: "create", method: "POST", target: "/users", body: `{"name":"Alan Turing","email":"Alan@Example.com"}`,
+			status: 201, want: map[string]any{"id": 3.0, "name": "Alan Turing", "email": "alan@example.com", "metadata": map[string]any{}}},
+		{name: "create preview", method: "POST", target: "/users?preview=true", body: `{"name":"Alan Turing","email":"alan@example.com"}`,
+			status: 200, want: map[string]any{"preview": true}},
+		{name: "create missing name", method: "POST", target: "/users", body: `{"email":"alan@example.com"}`,
+			status: 400, want: map[string]any{"errors": map[string]any{"name": "is required"}}},
+		{name: "create bad email", method: "POST", target: "/users", body: `{"name":"Alan","email":"alan"}`,
+			status: 400},
+		{name: "create malformed json", method: "POST", target: "/users", body: `{"name":`,
+			status: 400},
+		{name: "create duplicate email", method: "POST", target: "/users", body: `{"name":"Ada","email":"ADA@example.com"}`,
+			status: 409, want: map[string]any{"error": "email already in use"}},
+		{name: "create email of deleted user", method: "POST", target: "/users", body: `{"name":"Ada","email":"ada@example.com"}`,
+			setup:  func(r *fakeRepo) { r.Delete(context.Background(), 1) },
+			status: 409, want: map[string]any{"error": "email belongs to a deleted user", "user_id": 1.0}},
+		{name: "create nested metadata", method: "POST", target: "/users", body: `{"name":"Alan","email":"alan@example.com","metadata":{"a":{"b":1}}}`,
+			status: 400},
+
+		// POST /users/batch
+		{name: "batch", method: "POST", target: "/users/batch", body: `[{"name":"A","email":"a@example.com"},{"name":"B","email":"b@example.com"}]`,
+			status: 201, items: 2},
+		{name: "batch duplicate", method: "POST", target: "/users/batch", body: `[{"name":"A","email":"a@example.com"},{"name":"Ada","email":"ada@example.com"}]`,
+			status: 409, want: map[string]any{"index": 1.0}},
+		{name: "batch partial", method: "POST", target: "/users/batch?partial=true", body: `[{"name":"A","email":"a@example.com"},{"name":"Ada","email":"ada@example.com"}]`,
+			status: 200, want: map[string]any{"created": 1.0, "failed": 1.0}},
+		{name: "batch empty", method: "POST", target: "/users/batch", body: `[]`,
+			status: 400},
+
+		// PUT and PATCH /users/:id
+		{name: "update", method: "PUT", target: "/users/1", body: `{"name":"Ada King","email":"ada@example.com"}`,
+			status: 200, want: map[string]any{"name": "Ada King", "metadata": map[string]any{"team": "math"}}},
+		{name: "update missing", method: "PUT", target: "/users/99", body: `{"name":"X","email":"x@example.com"}`,
+			status: 404},
+		{name: "update to taken email", method: "PUT", target: "/users/1", body: `{"name":"Ada","email":"grace@example.com"}`,
+			status: 409},
+		{name: "update stale if-match", method: "PUT", target: "/users/1", body: `{"name":"Ada","email":"ada@example.com"}`,
+			header: []string{"If-Match", `"stale"`},
+			status: 412},
+		{name: "patch", method: "PATCH", target: "/users/2", body: `{"metadata":{"team":"cobol"}}`,
+			status: 200, want: map[string]any{"name": "Grace Hopper", "metadata": map[string]any{"team": "cobol"}}},
+		{name: "patch nothing", method: "PATCH", target: "/users/2", body: `{}`,
+			status: 400, want: map[string]any{"error": "no updatable fields provided"}},
+
+		// DELETE and restore
+		{name: "delete", method: "DELETE", target: "/users/1",
+			status: 200, want: map[string]any{"message": "user deleted"}},
+		{name: "delete twice", method: "DELETE", target: "/users/1",
+			setup:  func(r *fakeRepo) { r.Delete(context.Background(), 1) },
+			status: 404},
+		{name: "restore", method: "POST", target: "/admin/users/1/restore", header: []string{"X-API-Key", "admin-secret"},
+			setup:  func(r *fakeRepo) { r.Delete(context.Background(), 1) },
+			status: 200, want: map[string]any{"id": 1.0}},
+		{name: "restore live user", method: "POST", target: "/admin/users/1/restore", header: []string{"X-API-Key", "admin-secret"},
+			status: 404},
+
+		// POST /users/touch
+		{name: "touch", method: "POST", target: "/users/touch", body: `{"ids":[1,2,2,99]}`,
+			status: 200, want: map[string]any{"touched": 2.0}},
+		{name: "touch no ids", method: "POST", target: "/users/touch", body: `{"ids":[]}`,
+			status: 400},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := seedUsers()
+			if tc.setup != nil {
+				tc.setup(repo)
+			}
+			cfg := testConfig(t)
+			cfg.AdminAPIKey = "admin-secret"
+			r := testRouter(t, repo, cfg)
+
+			w := serve(r, tc.method, tc.target, tc.body, tc.header...)
+			if w.Code != tc.status {
+				t.Fatalf("%s %s = %d, want %d\n%s", tc.method, tc.target, w.Code, tc.status, w.Body)
+			}
+			// Every error names its request, so clients can report it
+			body := jsonObject(t, w)
+			if _, ok := body["request_id"]; tc.status >= 400 && !ok {
+				t.Errorf("error body has no request_id: %v", body)
+			}
+			for k, v := range tc.want {
+				if !reflect.DeepEqual(body[k], v) {
+					t.Errorf("%s = %#v, want %#v", k, body[k], v)
+				}
+			}
+			if items, ok := body["items"].([]any); ok && len(items) != tc.items {
+				t.Errorf("got %d items, want %d", len(items), tc.items)
+			}
+		})
+	}
+}
+
+// Crafted sort, order, limit and offset values are refused or replaced by
+// defaults; they never change what the listing means.
+func TestListCraftedParams(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MetadataSortKeys = map[string]bool{"team": true}
+	r := testRouter(t, seedUsers(), cfg)
+
+	for _, target := range []string{
+		"/users?sort=id%3BDROP%20TABLE%20users",
+		"/users?sort=name%20DESC",
+		"/users?sort=(SELECT%201)",
+		"/users?sort=metadata.team',id",
+		"/users?sort=metadata.role",
+		"/users?sort=name,name",
+	} {
+		if w := serve(r, "GET", target, ""); w.Code != 400 {
+			t.Errorf("GET %s = %d, want 400", target, w.Code)
+		}
+	}
+
+	plain := serve(r, "GET", "/users", "").Body.String()
+	for _, target := range []string{
+		"/users?order=desc%3BDROP%20TABLE%20users",
+		"/users?order=ASC%20NULLS%20FIRST",
+		"/users?limit=10%3BDELETE%20FROM%20users",
+		"/users?limit=-1",
+		"/users?offset=0%20OR%201=1",
+	} {
+		w := serve(r, "GET", target, "")
+		if w.Code != 200 {
+			t.Errorf("GET %s = %d, want 200", target, w.Code)
+			continue
+		}
+		if got := w.Body.String(); got != plain {
+			t.Errorf("GET %s = %s, want the default listing %s", target, got, plain)
+		}
+	}
+}
+
+// createConcurrently sends n identical POST /users at once and checks that
+// exactly one creates the user and the others are told the email is taken.
+func createConcurrently(t *testing.T, h http.Handler, email string, n int) {
+	t.Helper()
+	body := fmt.Sprintf(`{"name":"Racer","email":%q}`, email)
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve(h, "POST", "/users", body).Code
+		}()
+	}
+	wg.Wait()
+	count := map[int]int{}
+	for _, code := range codes {
+		count[code]++
+	}
+	if count[201] != 1 || count[409] != n-1 {
+		t.Errorf("%d identical creates: statuses %v, want one 201 and %d 409s", n, count, n-1)
+	}
+}
+
+func TestCreateRace(t *testing.T) {
+	repo := seedUsers()
+	createConcurrently(t, testRouter(t, repo, testConfig(t)), "racer@example.com", 50)
+	if n := len(repo.users); n != 3 {
+		t.Errorf("%d users after the race, want the 2 seeded and 1 created", n)
+	}
+}
+
+// The same against Postgres, where the unique index on email_key decides
+// the race. Set TEST_DB_URL to a scratch database to run it.
+func TestCreateRacePostgres(t *testing.T) {
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	ctx := context.Background()
+	cfg := testConfig(t)
+	cfg.DBURL = url
+	db, err := ConnectDB(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	email := fmt.Sprintf("race-%d@example.com", time.Now().UnixNano())
+	t.Cleanup(func() { db.Exec(ctx, "DELETE FROM users WHERE email_key = $1", email) })
+	createConcurrently(t, testRouter(t, NewUserRepository(db, cfg), cfg), email, 20)
+
+	var n int
+	if err := db.QueryRow(ctx, "SELECT count(*) FROM users WHERE email_key = $1", email).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d rows for %s, want 1", n, email)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Checker checks one dependency, e.g. (*pgxpool.Pool).Ping. It should
+// return promptly once ctx is done.
+type Checker func(ctx context.Context) error
+
+// readyTimeout bounds each check so a hung dependency fails the probe quickly.
+const readyTimeout = 2 * time.Second
+
+// readyCacheTTL is how long a readiness result is reused, so a storm of
+// probes costs at most one round of checks per second.
+const readyCacheTTL = time.Second
+
+// probeRoutes are the routes orchestrators and scrapers poll. Middleware
+// that could get in their way (rate limits, HTTPS redirects) skips them.
+var probeRoutes = map[string]bool{
+	"/healthz": true, "/readyz": true, "/health": true, "/health/live": true, "/health/ready": true, "/metrics": true,
+}
+
+// liveness reports that the process is up. It never touches dependencies,
+// so an orchestrator won't restart us just because Postgres is down.
+func liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readiness reports whether we can serve traffic, i.e. every check passes.
+// Checks run concurrently; the response lists each one as "ok", "timeout"
+// or "error":
+//
+//	200 {"status":"ok","checks":{"postgres":"ok"}}
+//	503 {"status":"degraded","checks":{"postgres":"timeout"}}
+func readiness(checks map[string]Checker) gin.HandlerFunc {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		results   map[string]string
+		healthy   bool
+	)
+	return func(c *gin.Context) {
+		// Concurrent probes wait for one round of checks rather than each running their own
+		mu.Lock()
+		if time.Since(checkedAt) >= readyCacheTTL {
+			// Detached from this request, since other probes share the result
+			results, healthy = runChecks(context.WithoutCancel(c), checks)
+			checkedAt = time.Now()
+		}
+		res, ok := results, healthy
+		mu.Unlock()
+
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded", "checks": res})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": res})
+	}
+}
+
+// runChecks runs every check with readyTimeout and reports each outcome.
+func runChecks(ctx context.Context, checks map[string]Checker) (map[string]string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(checks))
+	healthy := true
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := "ok"
+			if err := check(ctx); err != nil {
+				status = "error"
+				if errors.Is(err, context.DeadlineExceeded) {
+					status = "timeout"
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = status
+			healthy = healthy && status == "ok"
+		}()
+	}
+	wg.Wait()
+	return results, healthy
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// forceHTTPS returns middleware that redirects plain-HTTP requests to the
+// same URL on https:// and sends Strict-Transport-Security on HTTPS ones.
+// A request counts as HTTPS when it arrived over TLS, or when a trusted
+// proxy (TRUSTED_PROXIES) says so in X-Forwarded-Proto; anyone else could
+// set that header. GET and HEAD get a 301; other methods get a 308, so the
+// method and body survive the redirect. Health checks and /metrics are
+// left alone, since probes usually talk to the pod directly over HTTP.
+func forceHTTPS(trusted []string, hstsMaxAge time.Duration) gin.HandlerFunc {
+	var proxies []*net.IPNet
+	for _, p := range trusted {
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Fatalf("❌ Invalid TRUSTED_PROXIES: %v", err)
+		}
+		proxies = append(proxies, n)
+	}
+	hsts := "max-age=" + strconv.Itoa(int(hstsMaxAge.Seconds()))
+
+	isHTTPS := func(c *gin.Context) bool {
+		if c.Request.TLS != nil {
+			return true
+		}
+		ip := net.ParseIP(c.RemoteIP())
+		for _, n := range proxies {
+			if n.Contains(ip) {
+				// With several proxies the first one saw the client's scheme
+				proto, _, _ := strings.Cut(c.GetHeader("X-Forwarded-Proto"), ",")
+				return strings.EqualFold(strings.TrimSpace(proto), "https")
+			}
+		}
+		return false
+	}
+
+	return func(c *gin.Context) {
+		if probeRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if isHTTPS(c) {
+			if hstsMaxAge > 0 {
+				c.Header("Strict-Transport-Security", hsts)
+			}
+			c.Next()
+			return
+		}
+
+		status := http.StatusPermanentRedirect
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		c.Redirect(status, "https://"+c.Request.Host+c.Request.URL.RequestURI())
+		c.Abort()
+	}
+}
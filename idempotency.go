@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Idempotency-Key support for retried writes, e.g. POST /users after a
+// network timeout. The first request with a key runs normally and its
+// response is stored; a repeat within IDEMPOTENCY_TTL (default 24h) gets
+// the stored response back, marked Idempotent-Replayed: true, instead of
+// running again.
+//
+// Keys are scoped to the caller's API key, so two clients can't collide.
+// A key reused with a different request is a 422, and a repeat that
+// arrives while the first is still running is a 409.
+
+// maxIdempotencyKey bounds the header, since it's stored.
+const maxIdempotencyKey = 255
+
+// idempotencyHeaders are the response headers stored and replayed with the body.
+var idempotencyHeaders = []string{"Content-Type", "ETag"}
+
+// idempotent returns middleware that applies Idempotency-Key to the route,
+// keeping responses for ttl.
+// Requests without the header pass straight through, as do all requests
+// when there's no database to keep records in.
+func idempotent(db *pgxpool.Pool, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if db == nil {
+			c.Next()
+			return
+		}
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			abortError(c, http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		// The body is hashed with the method and URL to recognise "the same
+		// request", then put back for the handler
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortError(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.RequestURI()+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+		principal := c.GetString(principalKey)
+
+		reserved, err := reserveIdempotencyKey(c, db, ttl, principal, key, hash)
+		if err != nil {
+			c.Abort()
+			respondInternalError(c, err)
+			return
+		}
+		if !reserved {
+			replayIdempotent(c, db, principal, key, hash)
+			return
+		}
+
+		// Store the outcome even if the client has gone; that's the point
+		ctx := context.WithoutCancel(c)
+		release := func() error {
+			_, err := db.Exec(ctx, "DELETE FROM idempotency_keys WHERE principal=$1 AND key=$2", principal, key)
+			return err
+		}
+
+		// A panicking handler never gets to the recording below (Recovery is
+		// further out), so free the key on the way up; otherwise every retry
+		// would be "in progress" until the record expires
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := release(); err != nil {
+				logFor(c).Error("idempotency key not released", "key", key, "error", err)
+			}
+		}()
+
+		rec := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = rec
+		c.Next()
+		completed = true
+
+		status := rec.Status()
+		if status >= 500 {
+			// Server failures aren't final: free the key so a retry runs again
+			err = release()
+		} else {
+			headers := map[string]string{}
+			for _, h := range idempotencyHeaders {
+				if v := rec.Header().Get(h); v != "" {
+					headers[h] = v
+				}
+			}
+			_, err = db.Exec(ctx,
+				"UPDATE idempotency_keys SET status=$3, headers=$4, body=$5 WHERE principal=$1 AND key=$2",
+				principal, key, status, headers, rec.body.Bytes())
+		}
+		if err != nil {
+			logFor(c).Error("idempotency key not recorded", "key", key, "error", err)
+		}
+	}
+}
+
+// reserveIdempotencyKey claims key for a new request. It returns false when
+// a live record already exists; one older than ttl is taken over.
+func reserveIdempotencyKey(ctx context.Context, db *pgxpool.Pool, ttl time.Duration, principal, key, hash string) (bool, error) {
+	err := db.QueryRow(ctx,
+		`INSERT INTO idempotency_keys (principal, key, request_hash) VALUES ($1, $2, $3)
+		 ON CONFLICT (principal, key) DO UPDATE
+		   SET request_hash=EXCLUDED.request_hash, status=0, headers='{}', body=NULL, created_at=now()
+		   WHERE idempotency_keys.created_at < now() - $4 * interval '1 second'
+		 RETURNING true`,
+		principal, key, hash, int(ttl.Seconds()),
+	).Scan(new(bool))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// replayIdempotent answers a repeated key from its stored record.
+func replayIdempotent(c *gin.Context, db *pgxpool.Pool, principal, key, hash string) {
+	c.Abort()
+	var (
+		storedHash string
+		status     int
+		headers    map[string]string
+		body       []byte
+	)
+	err := db.QueryRow(c,
+		"SELECT request_hash, status, headers, body FROM idempotency_keys WHERE principal=$1 AND key=$2",
+		principal, key,
+	).Scan(&storedHash, &status, &headers, &body)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondInternalError(c, err)
+		return
+	}
+
+	switch {
+	case err == nil && storedHash != hash:
+		respondError(c, http.StatusUnprocessableEntity, gin.H{
+			"error": "Idempotency-Key was already used for a different request",
+			"code":  "idempotency_key_reused",
+		})
+	case err != nil || status == 0:
+		// Still running (or its record was just released after a failure)
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusConflict, gin.H{
+			"error": "a request with this Idempotency-Key is in progress",
+			"code":  "idempotency_key_in_progress",
+		})
+	default:
+		for h, v := range headers {
+			c.Header(h, v)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(status, headers["Content-Type"], body)
+	}
+}
+
+// bodyRecorder copies everything written to the response.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// purgeIdempotencyKeys deletes records older than ttl every hour until ctx
+// is done.
+// Expired keys are already ignored; this only keeps the table small.
+func purgeIdempotencyKeys(ctx context.Context, db *pgxpool.Pool, ttl time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := db.Exec(ctx, "DELETE FROM idempotency_keys WHERE created_at < now() - $1 * interval '1 second'",
+			int(ttl.Seconds()))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("❌ idempotency key purge failed: %v", err)
+		}
+	}
+}
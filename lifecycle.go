@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// Default per-component limits for lifecycle start and stop.
+const (
+	defaultStartTimeout = 30 * time.Second
+	defaultStopTimeout  = 5 * time.Second
+)
+
+// component is one subsystem managed by a lifecycle.
+type component struct {
+	name string
+	deps []string // components that must be started first (and stopped after)
+
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error // optional
+
+	startTimeout, stopTimeout time.Duration // 0 means the defaults above
+}
+
+// lifecycle starts components in dependency order and stops them in reverse.
+// If one fails to start, those already started are stopped before Start
+// returns, so a failed boot never leaves workers running.
+type lifecycle struct {
+	components []component
+	started    []component
+}
+
+// add registers c. Order of registration only breaks ties between
+// components that don't depend on each other.
+func (l *lifecycle) add(c component) {
+	l.components = append(l.components, c)
+}
+
+// background registers a component that runs fn in a goroutine until it is
+// stopped. Stopping cancels fn's context and waits for fn to return.
+func (l *lifecycle) background(name string, deps []string, fn func(ctx context.Context)) {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	l.add(component{
+		name: name,
+		deps: deps,
+		start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				fn(ctx)
+			}()
+			return nil
+		},
+		stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+}
+
+// order returns the components topologically sorted by deps. It fails on
+// unknown dependencies and on cycles.
+func (l *lifecycle) order() ([]component, error) {
+	byName := make(map[string]component, len(l.components))
+	for _, c := range l.components {
+		if _, dup := byName[c.name]; dup {
+			return nil, fmt.Errorf("component %q registered twice", c.name)
+		}
+		byName[c.name] = c
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var out []component
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %v", append(slices.Clip(path), name))
+		}
+		c, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("%s depends on unknown component %q", path[len(path)-1], name)
+		}
+		state[name] = visiting
+		for _, d := range c.deps {
+			if err := visit(d, append(slices.Clip(path), name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		out = append(out, c)
+		return nil
+	}
+	for _, c := range l.components {
+		if err := visit(c.name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Start starts every component in order and logs how long each took.
+func (l *lifecycle) Start(ctx context.Context) error {
+	ordered, err := l.order()
+	if err != nil {
+		return err
+	}
+	for _, c := range ordered {
+		began := time.Now()
+		cctx, cancel := context.WithTimeout(ctx, cmp.Or(c.startTimeout, defaultStartTimeout))
+		err := c.start(cctx)
+		cancel()
+		if err != nil {
+			l.Stop()
+			return fmt.Errorf("start %s: %w", c.name, err)
+		}
+		l.started = append(l.started, c)
+		log.Printf("✅ Started %s in %s", c.name, time.Since(began).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order. A component that
+// fails or times out is logged and the rest are still stopped.
+func (l *lifecycle) Stop() error {
+	var errs []error
+	for _, c := range slices.Backward(l.started) {
+		if c.stop == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(c.stopTimeout, defaultStopTimeout))
+		if err := c.stop(ctx); err != nil {
+			log.Printf("⚠️ Stopping %s: %v", c.name, err)
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.name, err))
+		}
+		cancel()
+	}
+	l.started = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder logs component starts and stops in the order they happen.
+type recorder struct {
+	events []string
+}
+
+// component returns a component that records its start and stop, and
+// fails to start with startErr if it's set.
+func (r *recorder) component(name string, startErr error, deps ...string) component {
+	return component{
+		name: name,
+		deps: deps,
+		start: func(context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		stop: func(context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	var rec recorder
+	var l lifecycle
+	// Registered out of order: the deps decide
+	l.add(rec.component("http", nil, "db", "metrics"))
+	l.add(rec.component("worker", nil, "migrate"))
+	l.add(rec.component("migrate", nil, "db"))
+	l.add(rec.component("db", nil))
+	l.add(rec.component("metrics", nil))
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	started := slices.Clone(rec.events)
+	want := []string{"start db", "start metrics", "start http", "start migrate", "start worker"}
+	if !slices.Equal(started, want) {
+		t.Errorf("started %q, want %q", started, want)
+	}
+
+	rec.events = nil
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"stop worker", "stop migrate", "stop http", "stop metrics", "stop db"}
+	if !slices.Equal(rec.events, want) {
+		t.Errorf("stopped %q, want %q", rec.events, want)
+	}
+}
+
+func TestLifecycleBadGraph(t *testing.T) {
+	tests := []struct {
+		name       string
+		components func(r *recorder) []component
+		want       string
+	}{
+		{"cycle", func(r *recorder) []component {
+			return []component{r.component("a", nil, "c"), r.component("b", nil, "a"), r.component("c", nil, "b")}
+		}, "dependency cycle: [a c b a]"},
+		{"self dependency", func(r *recorder) []component {
+			return []component{r.component("a", nil, "a")}
+		}, "dependency cycle: [a a]"},
+		{"unknown dependency", func(r *recorder) []component {
+			return []component{r.component("a", nil), r.component("b", nil, "a", "cache")}
+		}, `b depends on unknown component "cache"`},
+		{"duplicate", func(r *recorder) []component {
+			return []component{r.component("a", nil), r.component("a", nil)}
+		}, `component "a" registered twice`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rec recorder
+			var l lifecycle
+			for _, c := range tc.components(&rec) {
+				l.add(c)
+			}
+			err := l.Start(context.Background())
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("Start = %v, want %q", err, tc.want)
+			}
+			if len(rec.events) != 0 {
+				t.Errorf("a bad graph still ran %q", rec.events)
+			}
+		})
+	}
+}
+
+// A component failing to start stops the ones before it, in reverse, and
+// never stops itself or starts the ones after it.
+func TestLifecycleStartFailure(t *testing.T) {
+	var rec recorder
+	var l lifecycle
+	errBoom := errors.New("boom")
+	l.add(rec.component("db", nil))
+	l.add(rec.component("migrate", nil, "db"))
+	l.add(rec.component("worker", errBoom, "migrate"))
+	l.add(rec.component("http", nil, "worker"))
+
+	err := l.Start(context.Background())
+	if !errors.Is(err, errBoom) || !strings.HasPrefix(err.Error(), "start worker: ") {
+		t.Fatalf("Start = %v, want start worker: boom", err)
+	}
+	want := []string{"start db", "start migrate", "start worker", "stop migrate", "stop db"}
+	if !slices.Equal(rec.events, want) {
+		t.Errorf("events %q, want %q", rec.events, want)
+	}
+	if len(l.started) != 0 {
+		t.Errorf("%d components still marked started", len(l.started))
+	}
+}
+
+func TestLifecycleStartTimeout(t *testing.T) {
+	var l lifecycle
+	l.add(component{
+		name:         "slow",
+		start:        func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() },
+		startTimeout: 10 * time.Millisecond,
+	})
+	if err := l.Start(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Start = %v, want a deadline error", err)
+	}
+}
+
+// One component failing to stop doesn't keep the others running.
+func TestLifecycleStopFailure(t *testing.T) {
+	var rec recorder
+	var l lifecycle
+	l.add(rec.component("db", nil))
+	stuck := rec.component("worker", nil, "db")
+	stuck.stop = func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }
+	stuck.stopTimeout = 10 * time.Millisecond
+	l.add(stuck)
+	l.add(rec.component("http", nil, "worker"))
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec.events = nil
+	err := l.Stop()
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "stop worker") {
+		t.Errorf("Stop = %v, want stop worker: context deadline exceeded", err)
+	}
+	if want := []string{"stop http", "stop db"}; !slices.Equal(rec.events, want) {
+		t.Errorf("stopped %q, want %q", rec.events, want)
+	}
+}
+
+func TestLifecycleBackground(t *testing.T) {
+	var l lifecycle
+	running := make(chan struct{})
+	exited := false
+	l.background("worker", nil, func(ctx context.Context) {
+		close(running)
+		<-ctx.Done()
+		exited = true
+	})
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-running
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if !exited {
+		t.Error("Stop returned before the worker did")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key holding the request ID.
+type requestIDKey struct{}
+
+// setupLogging makes every log line (slog and the standard log package)
+// a JSON object on stdout, for the log pipeline. Records below level are
+// dropped; standard log lines are never dropped, since they carry startup
+// and shutdown messages.
+func setupLogging(level slog.Level) {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	slog.SetLogLoggerLevel(max(level, slog.LevelInfo))
+}
+
+// requestID is middleware that takes the ID from X-Request-ID or generates a
+// UUID, echoes it in the response and stores it in the request context.
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		id = uuid.NewString()
+	}
+	c.Header(requestIDHeader, id)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+	c.Next()
+}
+
+// requestIDFrom returns the request ID stored in ctx, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logFor returns a logger tagged with ctx's request ID, for use below the
+// HTTP layer (e.g. DB errors) so lines can be joined to their request.
+func logFor(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// logRequests is middleware that emits one JSON line per request. Errors
+// attached with c.Error are logged here (at warn for 4xx, error for 5xx)
+// rather than sent to the client.
+func logRequests(c *gin.Context) {
+	start := time.Now()
+	// Count what handlers actually read; Content-Length is absent when chunked
+	body := &countingReader{ReadCloser: c.Request.Body}
+	c.Request.Body = body
+	c.Next()
+
+	status := c.Writer.Status()
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	attrs := []any{
+		"request_id", requestIDFrom(c.Request.Context()),
+		"method", c.Request.Method,
+		"route", route,
+		"path", c.Request.URL.Path,
+		"status", status,
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"client_ip", c.ClientIP(),
+		"request_bytes", body.n,
+		"response_bytes", c.Writer.Size(),
+	}
+	if p := c.GetString(principalKey); p != "" {
+		attrs = append(attrs, "principal", p)
+	}
+	if len(c.Errors) > 0 {
+		attrs = append(attrs, "error", c.Errors.String())
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	slog.Log(c, level, "request", attrs...)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
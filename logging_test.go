@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// captureLogs sends slog output to the returned buffer until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// requestLogs returns the "request" lines in buf, each of which must be a
+// JSON object.
+func requestLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line is not JSON: %v\n%s", err, line)
+		}
+		if rec["msg"] == "request" {
+			lines = append(lines, rec)
+		}
+	}
+	return lines
+}
+
+func TestRequestID(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+
+	// A client's ID comes back in the header and in error bodies
+	w := serve(r, "GET", "/users/99", "", "X-Request-ID", "trace-abc-123")
+	if got := w.Header().Get("X-Request-ID"); got != "trace-abc-123" {
+		t.Errorf("X-Request-ID = %q, want the client's", got)
+	}
+	if got := jsonObject(t, w)["request_id"]; got != "trace-abc-123" {
+		t.Errorf("request_id = %v, want the client's", got)
+	}
+
+	// Without one (or with an unreasonably long one) a UUID is generated
+	for _, sent := range []string{"", strings.Repeat("x", 129)} {
+		w := serve(r, "GET", "/users/1", "", "X-Request-ID", sent)
+		if _, err := uuid.Parse(w.Header().Get("X-Request-ID")); err != nil {
+			t.Errorf("sent %d-byte ID, got X-Request-ID %q: %v", len(sent), w.Header().Get("X-Request-ID"), err)
+		}
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	logs := captureLogs(t)
+	r := testRouter(t, seedUsers(), testConfig(t))
+
+	w := serve(r, "GET", "/users/1", "", "X-Request-ID", "log-1")
+	lines := requestLogs(t, logs)
+	if len(lines) != 1 {
+		t.Fatalf("got %d request lines, want 1", len(lines))
+	}
+	rec := lines[0]
+	for k, want := range map[string]any{
+		"level":          "INFO",
+		"request_id":     "log-1",
+		"method":         "GET",
+		"route":          "/users/:id",
+		"path":           "/users/1",
+		"status":         200.0,
+		"client_ip":      "192.0.2.1",
+		"request_bytes":  0.0,
+		"response_bytes": float64(w.Body.Len()),
+	} {
+		if rec[k] != want {
+			t.Errorf("%s = %#v, want %#v", k, rec[k], want)
+		}
+	}
+	if _, ok := rec["latency_ms"].(float64); !ok {
+		t.Errorf("latency_ms = %#v, want a number", rec["latency_ms"])
+	}
+}
+
+// The underlying error is logged at error level but never sent to the client.
+func TestRequestLogError(t *testing.T) {
+	logs := captureLogs(t)
+	repo := seedUsers()
+	repo.err = errors.New("dial tcp 10.0.0.5:5432: connection refused")
+	r := testRouter(t, repo, testConfig(t))
+
+	w := serve(r, "GET", "/users/1", "")
+	if w.Code != 500 || strings.Contains(w.Body.String(), "10.0.0.5") {
+		t.Errorf("response = %d %s, want a bare 500", w.Code, w.Body)
+	}
+	rec := requestLogs(t, logs)[0]
+	if rec["level"] != "ERROR" || !strings.Contains(rec["error"].(string), "connection refused") {
+		t.Errorf("log line = %v, want level ERROR with the error", rec)
+	}
+
+	serve(r, "GET", "/users/abc", "")
+	if rec := requestLogs(t, logs)[1]; rec["level"] != "WARN" || rec["status"] != 400.0 {
+		t.Errorf("log line = %v, want level WARN for a 400", rec)
+	}
+}
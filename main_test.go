@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testConfig returns the default configuration, with rate limiting off so
+// tests can send as many requests as they like.
+func testConfig(t testing.TB) Config {
+	t.Helper()
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RateLimit = 0
+	return cfg
+}
+
+// testRouter returns the full router over repo, without a database.
+func testRouter(t testing.TB, repo UserRepository, cfg Config) *gin.Engine {
+	t.Helper()
+	return NewRouter(RouterDeps{Users: repo, Config: cfg})
+}
+
+// serve sends a request through h and returns the recorded response.
+// header holds name, value pairs.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// jsonObject decodes a response body that must be a JSON object.
+func jsonObject(t testing.TB, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("body is not a JSON object: %v\n%s", err, w.Body)
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Settings for the free-form users.metadata object.
+//
+//	METADATA_MAX_DEPTH  nesting limit; 1 (the default) means a flat object
+//	                    whose values must be scalars
+//	METADATA_MAX_BYTES  size limit of the encoded object (default 4096)
+//	METADATA_SORT_KEYS  comma-separated keys GET /users may sort by
+//	                    (?sort=metadata.<key>). Any other metadata key is a 400
+//	                    (see parseSort), so clients can't force sorts on
+//	                    arbitrary unindexed keys.
+
+// metadataKeyPattern restricts sortable keys to plain identifiers, since they
+// end up as literals in ORDER BY.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseMetadataSortKeys parses METADATA_SORT_KEYS into a set.
+func parseMetadataSortKeys(s string) (map[string]bool, error) {
+	keys := map[string]bool{}
+	for _, k := range splitList(s) {
+		if !metadataKeyPattern.MatchString(k) {
+			return nil, fmt.Errorf("METADATA_SORT_KEYS: invalid key %q", k)
+		}
+		keys[k] = true
+	}
+	return keys, nil
+}
+
+// metadataSortExpr returns the ORDER BY expression for an allowlisted metadata key.
+// Values compare as text (->>), so numbers sort lexically.
+func metadataSortExpr(key string) string {
+	return "metadata->>'" + key + "'"
+}
+
+// validateMetadata checks that metadata is within the configured depth and
+// size (METADATA_MAX_DEPTH, METADATA_MAX_BYTES). A nil map is valid (it
+// means "not provided").
+func validateMetadata(m map[string]any, cfg Config) error {
+	if m == nil {
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("metadata: %v", err)
+	}
+	if len(b) > cfg.MetadataMaxBytes {
+		return fmt.Errorf("metadata must be at most %d bytes", cfg.MetadataMaxBytes)
+	}
+	if depth(m) > cfg.MetadataMaxDepth {
+		return fmt.Errorf("metadata must be nested at most %d level(s) deep", cfg.MetadataMaxDepth)
+	}
+	return nil
+}
+
+// depth returns how many object/array levels v spans (scalars are 0).
+func depth(v any) int {
+	d := 0
+	switch t := v.(type) {
+	case map[string]any:
+		for _, e := range t {
+			d = max(d, depth(e))
+		}
+	case []any:
+		for _, e := range t {
+			d = max(d, depth(e))
+		}
+	default:
+		return 0
+	}
+	return d + 1
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// HTTP metrics. The route label is the Gin route template ("/users/:id"),
+// never the raw path, so cardinality stays bounded.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests processed, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+)
+
+// Statement cache metrics, fed by queryTracer. These approximate what pgx
+// doesn't expose: they are pool-wide counters, with no per-connection cache
+// size, and hits are only the difference, 1 - prepares/queries. With
+// DB_STATEMENT_CACHE_CAPACITY=0 every query is prepared, i.e. misses.
+var (
+	dbQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "db_queries_total",
+		Help: "Queries sent to Postgres (batched queries excluded).",
+	})
+
+	dbPrepares = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "db_statement_prepares_total",
+		Help: "Statements prepared, i.e. statement cache misses.",
+	})
+)
+
+// recordMetrics is middleware that feeds the HTTP metrics above.
+// Scrapes of /metrics itself are left out, so they don't add noise.
+func recordMetrics(c *gin.Context) {
+	if c.FullPath() == "/metrics" {
+		c.Next()
+		return
+	}
+	start := time.Now()
+	httpInFlight.Inc()
+	defer httpInFlight.Dec()
+
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched" // 404s would otherwise explode cardinality
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+	httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+}
+
+// POOL_STATS_INTERVAL (e.g. 5s) makes pool statistics come from a sample
+// taken that often instead of at scrape time, so dashboards see evenly
+// spaced points however irregular the scrapes are. 0 samples on scrape.
+
+// poolCollector exports pgxpool statistics: the latest sample if run is
+// sampling, otherwise pool.Stat() read on each scrape.
+type poolCollector struct {
+	pool *pgxpool.Pool
+	last atomic.Pointer[pgxpool.Stat]
+
+	acquired, idle, total, max *prometheus.Desc
+	acquireCount, acquireWait  *prometheus.Desc
+}
+
+// newPoolCollector returns a collector for pool; register it once at startup.
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	return &poolCollector{
+		pool:         pool,
+		acquired:     prometheus.NewDesc("pgxpool_acquired_conns", "Connections currently checked out.", nil, nil),
+		idle:         prometheus.NewDesc("pgxpool_idle_conns", "Idle connections in the pool.", nil, nil),
+		total:        prometheus.NewDesc("pgxpool_total_conns", "Total connections in the pool.", nil, nil),
+		max:          prometheus.NewDesc("pgxpool_max_conns", "Maximum pool size.", nil, nil),
+		acquireCount: prometheus.NewDesc("pgxpool_acquire_total", "Successful connection acquires.", nil, nil),
+		acquireWait:  prometheus.NewDesc("pgxpool_acquire_duration_seconds_total", "Total time spent waiting to acquire connections.", nil, nil),
+	}
+}
+
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.acquired
+	ch <- p.idle
+	ch <- p.total
+	ch <- p.max
+	ch <- p.acquireCount
+	ch <- p.acquireWait
+}
+
+// run samples the pool every interval until ctx is cancelled.
+// It returns at once when sampling is off (interval <= 0).
+func (p *poolCollector) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.last.Store(p.pool.Stat())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := p.last.Load()
+	if s == nil {
+		s = p.pool.Stat()
+	}
+	ch <- prometheus.MustNewConstMetric(p.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(p.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(p.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(p.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(p.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(p.acquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
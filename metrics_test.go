@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrape returns the value of the sample named series (name plus labels,
+// exactly as /metrics prints them), or 0 when it isn't there yet.
+func scrape(t *testing.T, r http.Handler, series string) float64 {
+	t.Helper()
+	w := serve(r, "GET", "/metrics", "")
+	if w.Code != 200 {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	// The metrics are process-wide, so compare before and after
+	ok := `http_requests_total{method="GET",route="/users/:id",status="200"}`
+	missing := `http_requests_total{method="GET",route="/users/:id",status="404"}`
+	unmatched := `http_requests_total{method="GET",route="unmatched",status="404"}`
+	latency := `http_request_duration_seconds_count{method="GET",route="/users/:id",status="200"}`
+	scrapes := `http_requests_total{method="GET",route="/metrics",status="200"}`
+	before := map[string]float64{}
+	for _, s := range []string{ok, missing, unmatched, latency, scrapes} {
+		before[s] = scrape(t, r, s)
+	}
+
+	serve(r, "GET", "/users/1", "")
+	serve(r, "GET", "/users/2", "")
+	serve(r, "GET", "/users/99", "")
+	serve(r, "GET", "/no/such/route/123", "")
+
+	for s, want := range map[string]float64{ok: 2, missing: 1, unmatched: 1, latency: 2, scrapes: 0} {
+		if got := scrape(t, r, s) - before[s]; got != want {
+			t.Errorf("%s moved by %v, want %v", s, got, want)
+		}
+	}
+	// Raw paths never become labels
+	if body := serve(r, "GET", "/metrics", "").Body.String(); strings.Contains(body, `route="/users/1"`) {
+		t.Error("a raw path was used as a route label")
+	}
+	if got := scrape(t, r, "http_requests_in_flight"); got != 0 {
+		t.Errorf("http_requests_in_flight = %v between requests, want 0", got)
+	}
+}
+
+func TestPoolCollector(t *testing.T) {
+	// Never connects: the stats are all about the configured pool
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost:1/none?pool_max_conns=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newPoolCollector(pool))
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, f := range families {
+		m := f.GetMetric()[0]
+		got[f.GetName()] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+	}
+	for name, want := range map[string]float64{
+		"pgxpool_max_conns":                      7,
+		"pgxpool_acquired_conns":                 0,
+		"pgxpool_idle_conns":                     0,
+		"pgxpool_total_conns":                    0,
+		"pgxpool_acquire_total":                  0,
+		"pgxpool_acquire_duration_seconds_total": 0,
+	} {
+		if v, ok := got[name]; !ok || v != want {
+			t.Errorf("%s = %v (present: %v), want %v", name, v, ok, want)
+		}
+	}
+}
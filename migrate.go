@@ -0,0 +1,141 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationFiles are the schema migrations, NNNN_name.up.sql with a matching
+// .down.sql for rolling back by hand. Only the up files are run here.
+//
+//go:embed db/migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that serializes migration
+// runs, so replicas starting together don't race. Any constant works as
+// long as nothing else in the database uses it.
+const migrationLockID = 0x6d696772 // "migr"
+
+// migrateTimeout bounds a whole migration run at startup, including the
+// wait for another replica's run to finish.
+const migrateTimeout = 5 * time.Minute
+
+// migration is one versioned up migration.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func (m migration) String() string { return fmt.Sprintf("%04d_%s", m.version, m.name) }
+
+// loadMigrations reads the embedded up migrations, sorted by version.
+func loadMigrations() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "db/migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+	var out []migration
+	for _, p := range paths {
+		base := strings.TrimSuffix(strings.TrimPrefix(p, "db/migrations/"), ".up.sql")
+		v, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must look like NNNN_name.up.sql", p)
+		}
+		sql, err := migrationFiles.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, migration{version: version, name: name, sql: string(sql)})
+	}
+	slices.SortFunc(out, func(a, b migration) int { return cmp.Compare(a.version, b.version) })
+	for i := 1; i < len(out); i++ {
+		if out[i].version == out[i-1].version {
+			return nil, fmt.Errorf("migrations %s and %s share a version", out[i-1], out[i])
+		}
+	}
+	return out, nil
+}
+
+// migrate applies every embedded migration not yet recorded in
+// schema_migrations, in version order, each in its own transaction
+// together with its schema_migrations row. The first failure stops the
+// run and is returned; later migrations are left for the next start.
+//
+// The migrations written before this runner existed are idempotent (IF NOT
+// EXISTS), so a database set up by hand catches up safely on first run.
+func migrate(ctx context.Context, db *pgxpool.Pool) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// The advisory lock and the statement_timeout override are per session,
+	// so everything runs on one connection
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	// Migrations and the lock wait may outlast DB_STATEMENT_TIMEOUT;
+	// migrateTimeout bounds them instead
+	if _, err := conn.Exec(ctx, "SET statement_timeout = 0"); err != nil {
+		return err
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "RESET statement_timeout")
+
+	// A second replica waits here, then finds everything applied
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if slices.Contains(applied, m.version) {
+			continue
+		}
+		began := time.Now()
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m, err)
+		}
+		log.Printf("✅ Applied migration %s in %s", m, time.Since(began).Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI 3 description of the API, served at GET /openapi.json with a
+// Swagger UI at GET /docs. Paths are written out by hand below; the schemas
+// of request and response bodies are derived from the Go types handlers
+// actually bind and encode (see schemaFor), so json tags and validation
+// rules can't drift from the document. NewRouter logs any registered route
+// the document doesn't describe (see checkSpecRoutes).
+
+// serveOpenAPI returns the GET /openapi.json handler. The document is built
+// once, since it only depends on code and startup settings.
+func serveOpenAPI(spec gin.H) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	}
+}
+
+// serveDocs renders Swagger UI for /openapi.json. The UI assets come from a
+// CDN, so /docs needs internet access in the browser, not on the server.
+func serveDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
+
+const docsPage = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-rest-api</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#ui"});</script>
+</body>
+</html>`
+
+// openAPISpec builds the OpenAPI document. Limits it states come from cfg.
+func openAPISpec(cfg Config) gin.H {
+	userPath := []gin.H{pathParam("id", "integer", "user ID")}
+	// The filters of GET /users that its dedicated export routes take too
+	exportFilters := []gin.H{
+		queryParam("q", "string", "as for GET /users"),
+		queryParam("sort", "string", "as for GET /users"),
+		queryParam("order", "string", "as for GET /users"),
+		queryParam("created_after", "string", "as for GET /users"),
+		queryParam("created_before", "string", "as for GET /users"),
+		queryParam("include_deleted", "boolean", "as for GET /users (admin key only)"),
+		{"name": "metadata.<key>", "in": "query", "schema": gin.H{"type": "string"}, "description": "exact match on a top-level metadata key"},
+	}
+	fields := queryParam("fields", "string", "comma-separated fields to return ("+strings.Join(userFields, ", ")+"); id is always included")
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "go-rest-api",
+			"version": "1.0.0",
+			"description": "CRUD API for users. Every error response has the Error shape, " +
+				"including the request_id to quote when reporting a problem.",
+		},
+		"components": gin.H{
+			"securitySchemes": gin.H{
+				"apiKey":         gin.H{"type": "http", "scheme": "bearer", "description": "one of API_KEYS (or ADMIN_API_KEY); writes are open when none are configured"},
+				"apiKeyHeader":   gin.H{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "the same key as a header"},
+				"adminKey":       gin.H{"type": "http", "scheme": "bearer", "description": "ADMIN_API_KEY"},
+				"adminKeyHeader": gin.H{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "ADMIN_API_KEY as a header"},
+			},
+			"schemas": gin.H{
+				"User":           schemaFor(reflect.TypeFor[User]()),
+				"UserInput":      schemaFor(reflect.TypeFor[userBody]()),
+				"UserList":       schemaFor(reflect.TypeFor[userList]()),
+				"UserPatch":      schemaFor(reflect.TypeFor[userPatchBody]()),
+				"Facet":          schemaFor(reflect.TypeFor[Facet]()),
+				"DeliveryStatus": schemaFor(reflect.TypeFor[deliveryStatus]()),
+				"SQLCount":       schemaFor(reflect.TypeFor[sqlCount]()),
+				"Error": gin.H{
+					"type":     "object",
+					"required": []string{"request_id"},
+					"properties": gin.H{
+						"error":      gin.H{"type": "string", "description": "what went wrong"},
+						"errors":     gin.H{"type": "object", "additionalProperties": gin.H{"type": "string"}, "description": "per-field validation messages, instead of error"},
+						"code":       gin.H{"type": "string", "description": "machine-readable reason, where one exists"},
+						"request_id": gin.H{"type": "string"},
+						"index":      gin.H{"type": "integer", "description": "failing row of a batch"},
+						"user_id":    gin.H{"type": "integer", "description": "deleted user holding the email"},
+						"hint":       gin.H{"type": "string"},
+					},
+				},
+			},
+		},
+		"paths": gin.H{
+			"/healthz":      gin.H{"get": probe("Liveness probe", false)},
+			"/health/live":  gin.H{"get": probe("Liveness probe (alias of /healthz)", false)},
+			"/readyz":       gin.H{"get": probe("Readiness probe", true)},
+			"/health/ready": gin.H{"get": probe("Readiness probe (alias of /readyz)", true)},
+			"/health":       gin.H{"get": probe("Readiness probe (alias of /readyz)", true)},
+			"/metrics":      gin.H{"get": gin.H{"summary": "Prometheus metrics", "responses": gin.H{"200": textResponse("text exposition format")}}},
+			"/openapi.json": gin.H{"get": gin.H{"summary": "This document", "responses": gin.H{"200": gin.H{"description": "OpenAPI 3 document"}}}},
+			"/docs":         gin.H{"get": gin.H{"summary": "Swagger UI for this document", "responses": gin.H{"200": textResponse("HTML page")}}},
+			"/users/schema": gin.H{"get": gin.H{"summary": "JSON Schema of the user payloads, for building forms", "responses": gin.H{"200": gin.H{"description": "create and update schemas (draft 2020-12) with labels and error codes"}}}},
+			"/users/by-email/{email}": gin.H{"get": gin.H{
+				"summary":    "Get a user by email",
+				"parameters": []gin.H{pathParam("email", "string", "email address, matched case-insensitively")},
+				"responses":  gin.H{"200": userResponse("the user"), "304": notModified(), "400": errorResponse("invalid email"), "404": errorResponse("no such user")},
+			}},
+			"/users": gin.H{
+				"get": gin.H{
+					"summary": "List users",
+					"parameters": []gin.H{
+						queryParam("q", "string", "search name and email (at least SEARCH_MIN_LENGTH characters)"),
+						queryParam("limit", "integer", "page size, 1-100 (default 10)"),
+						queryParam("offset", "integer", "rows to skip; can't be combined with cursor"),
+						queryParam("cursor", "string", "next_cursor of the previous page"),
+						fields,
+						queryParam("item_cursors", "boolean", "give each item a cursor that continues the listing after it (single non-metadata sort only)"),
+						queryParam("snapshot", "boolean", "on the first page: later pages (via next_cursor) leave out users created after it"),
+						queryParam("sort", "string", "comma-separated columns (id, name, email, created_at, updated_at, metadata.<key>), \"-\" prefix for descending, e.g. name,-created_at (default id)"),
+						queryParam("order", "string", "direction of sort columns without \"-\": asc or desc (default asc)"),
+						queryParam("created_after", "string", "RFC3339 timestamp or YYYY-MM-DD, inclusive"),
+						queryParam("created_before", "string", "RFC3339 timestamp or YYYY-MM-DD, exclusive"),
+						queryParam("facets", "string", "comma-separated facet names: "+strings.Join(slices.Sorted(maps.Keys(userFacets)), ", ")),
+						queryParam("include_deleted", "boolean", "also list soft-deleted users (admin key only)"),
+						queryParam("format", "string", "json (default), or csv or ndjson to export every match (all of them unless limit is given)"),
+						queryParam("columns", "string", "with format=csv, all appends "+strings.Join(csvExtraColumns, ", ")+" to the columns"),
+						{"name": "metadata.<key>", "in": "query", "schema": gin.H{"type": "string"}, "description": "exact match on a top-level metadata key"},
+						{"name": "Prefer", "in": "header", "schema": gin.H{"type": "string"}, "description": `"streaming" returns a bare JSON array with metadata in headers`},
+					},
+					"responses": gin.H{
+						"200": gin.H{"description": "a page of users, or an export", "content": gin.H{
+							"application/json":     gin.H{"schema": ref("UserList")},
+							"text/csv":             gin.H{"schema": gin.H{"type": "string"}},
+							"application/x-ndjson": gin.H{"schema": gin.H{"type": "string"}},
+						}},
+						"400": errorResponse("invalid parameter"),
+						"403": errorResponse("include_deleted without the admin key"),
+					},
+				},
+				"post": write(gin.H{
+					"summary": "Create a user",
+					"parameters": []gin.H{
+						queryParam("preview", "boolean", "validate and return the would-be user without saving it"),
+						headerParam("Idempotency-Key", "retries with the same key and body get the first response back instead of creating again"),
+					},
+					"requestBody": jsonBody(ref("UserInput")),
+					"responses": gin.H{
+						"201": userResponse("the created user"),
+						"422": errorResponse("Idempotency-Key reused for a different request"),
+						"200": jsonResponse("preview of the user", gin.H{"type": "object", "properties": gin.H{"preview": gin.H{"type": "boolean"}, "user": ref("User")}}),
+						"400": errorResponse("invalid body"),
+						"409": errorResponse("email already in use, or a request with this Idempotency-Key is in progress"),
+					},
+				}),
+			},
+			"/users/batch": gin.H{"post": write(gin.H{
+				"summary":     "Create up to " + strconv.Itoa(maxBatchUsers) + " users in one transaction",
+				"parameters":  []gin.H{queryParam("partial", "boolean", "skip bad rows and report a result per row")},
+				"requestBody": jsonBody(gin.H{"type": "array", "items": ref("UserInput"), "minItems": 1, "maxItems": maxBatchUsers}),
+				"responses": gin.H{
+					"201": jsonResponse("all users created", gin.H{"type": "object", "properties": gin.H{"items": gin.H{"type": "array", "items": ref("User")}}}),
+					"200": jsonResponse("per-row results (partial=true)", gin.H{"type": "object", "properties": gin.H{
+						"created": gin.H{"type": "integer"},
+						"failed":  gin.H{"type": "integer"},
+						"results": gin.H{"type": "array", "items": gin.H{"type": "object", "properties": gin.H{
+							"index":  gin.H{"type": "integer"},
+							"status": gin.H{"type": "integer"},
+							"user":   ref("User"),
+							"error":  gin.H{"type": "string"},
+						}}},
+					}}),
+					"400": errorResponse("invalid row (see index)"),
+					"409": errorResponse("email already in use (see index)"),
+				},
+			})},
+			"/users/touch": gin.H{"post": write(gin.H{
+				"summary": "Bump updated_at without changing data",
+				"requestBody": jsonBody(gin.H{"type": "object", "required": []string{"ids"}, "properties": gin.H{
+					"ids": gin.H{"type": "array", "items": gin.H{"type": "integer", "minimum": 1}, "minItems": 1, "maxItems": cfg.MaxBatchIDs},
+				}}),
+				"responses": gin.H{
+					"200": jsonResponse("number of users touched", gin.H{"type": "object", "properties": gin.H{"touched": gin.H{"type": "integer"}}}),
+					"400": errorResponse("invalid body"),
+				},
+			})},
+			"/users.csv": gin.H{"get": gin.H{
+				"summary":    "Export every matching user as CSV",
+				"parameters": append(slices.Clip(exportFilters), queryParam("columns", "string", "all appends "+strings.Join(csvExtraColumns, ", ")+" to the columns")),
+				"responses": gin.H{
+					"200": gin.H{"description": "a header row (" + strings.Join(csvColumns, ",") + ", unless columns=all), then one row per user; limit and offset don't apply", "content": gin.H{
+						"text/csv": gin.H{"schema": gin.H{"type": "string"}},
+					}},
+					"400": errorResponse("invalid parameter"),
+					"403": errorResponse("include_deleted without the admin key"),
+				},
+			}},
+			"/users/export": gin.H{"get": gin.H{
+				"summary":    "Export every matching user as NDJSON",
+				"parameters": exportFilters,
+				"responses": gin.H{
+					"200": gin.H{"description": "one user object per line; limit and offset don't apply", "content": gin.H{
+						"application/x-ndjson": gin.H{"schema": gin.H{"type": "string"}},
+					}},
+					"400": errorResponse("invalid parameter"),
+					"403": errorResponse("include_deleted without the admin key"),
+				},
+			}},
+			"/users/{id}": gin.H{
+				"get": gin.H{
+					"summary":    "Get a user",
+					"parameters": append([]gin.H{headerParam("If-None-Match", "ETag of a cached copy"), fields}, userPath...),
+					"responses":  gin.H{"200": userResponse("the user"), "304": notModified(), "400": errorResponse("invalid id"), "404": errorResponse("no such user")},
+				},
+				"put": write(gin.H{
+					"summary":     "Replace a user's fields (metadata is kept when omitted)",
+					"parameters":  append([]gin.H{ifMatchParam()}, userPath...),
+					"requestBody": jsonBody(ref("UserInput")),
+					"responses":   conditionalWriteResponses(),
+				}),
+				"patch": write(gin.H{
+					"summary":     "Update only the given fields",
+					"parameters":  append([]gin.H{ifMatchParam()}, userPath...),
+					"requestBody": jsonBody(ref("UserPatch")),
+					"responses":   conditionalWriteResponses(),
+				}),
+				"delete": write(gin.H{
+					"summary":    "Soft-delete a user",
+					"parameters": userPath,
+					"responses": gin.H{
+						"200": jsonResponse("confirmation", gin.H{"type": "object", "properties": gin.H{"message": gin.H{"type": "string"}}}),
+						"404": errorResponse("no such user"),
+					},
+				}),
+			},
+			"/admin/users/{id}/restore": gin.H{"post": admin(gin.H{
+				"summary":    "Undo a soft delete",
+				"parameters": userPath,
+				"responses":  gin.H{"200": userResponse("the restored user"), "404": errorResponse("no deleted user with this id")},
+			})},
+			"/users/{id}/counters": gin.H{"post": write(gin.H{
+				"summary":     "Add to counters atomically",
+				"description": "Each named counter moves by its inc (negative to decrement) in one statement, so concurrent calls never lose increments. Unless COUNTERS_TOUCH_UPDATED_AT is set, updated_at and the ETag stay the same.",
+				"parameters":  userPath,
+				"requestBody": jsonBody(gin.H{
+					"type":                 "object",
+					"example":              gin.H{"login_count": gin.H{"inc": 1}},
+					"additionalProperties": gin.H{"type": "object", "required": []string{"inc"}, "properties": gin.H{"inc": gin.H{"type": "integer"}}},
+					"description":          "counter name (" + strings.Join(userCounters, ", ") + ") to change",
+				}),
+				"responses": gin.H{
+					"200": userResponse("the user with its new counts"),
+					"400": errorResponse("unknown counter or missing inc"),
+					"404": errorResponse("no such user"),
+					"409": errorResponse("a counter would go below zero (code counter_negative) or overflow (code counter_overflow); nothing changed"),
+				},
+			})},
+			"/admin/webhooks/deliveries": gin.H{"get": admin(gin.H{
+				"summary": "Recent webhook deliveries, newest first",
+				"parameters": []gin.H{
+					queryParam("status", "string", "only deliveries in this status"),
+					queryParam("limit", "integer", "page size, 1-200 (default 50)"),
+					queryParam("offset", "integer", "rows to skip"),
+				},
+				"responses": gin.H{"200": jsonResponse("a page of deliveries", gin.H{"type": "object", "properties": gin.H{
+					"items":  gin.H{"type": "array", "items": ref("DeliveryStatus")},
+					"limit":  gin.H{"type": "integer"},
+					"offset": gin.H{"type": "integer"},
+					"status": gin.H{"type": "string"},
+				}})},
+			})},
+			"/admin/webhooks/deliveries/{id}/retry": gin.H{"post": admin(gin.H{
+				"summary":    "Attempt a delivery again now",
+				"parameters": []gin.H{pathParam("id", "integer", "delivery ID")},
+				"responses":  gin.H{"202": jsonResponse("the re-queued delivery", ref("DeliveryStatus")), "404": errorResponse("no such delivery")},
+			})},
+			"/admin/debug/sql": gin.H{"get": admin(gin.H{
+				"summary":    "Distinct SQL texts run recently, with counts",
+				"parameters": []gin.H{queryParam("minutes", "integer", "window, 1-60 (default 10)")},
+				"responses": gin.H{"200": jsonResponse("SQL texts, most frequent first", gin.H{"type": "object", "properties": gin.H{
+					"items":    gin.H{"type": "array", "items": ref("SQLCount")},
+					"minutes":  gin.H{"type": "integer"},
+					"distinct": gin.H{"type": "integer"},
+				}})},
+			})},
+		},
+	}
+}
+
+// write marks op as needing an API key (see requireAPIKey).
+func write(op gin.H) gin.H {
+	op["security"] = []gin.H{{"apiKey": []string{}}, {"apiKeyHeader": []string{}}}
+	op["responses"].(gin.H)["401"] = errorResponse("no API key sent")
+	op["responses"].(gin.H)["403"] = errorResponse("unknown API key")
+	return op
+}
+
+// admin marks op as needing the admin key (see requireAdmin).
+func admin(op gin.H) gin.H {
+	op["security"] = []gin.H{{"adminKey": []string{}}, {"adminKeyHeader": []string{}}}
+	op["responses"].(gin.H)["401"] = errorResponse("no admin key sent")
+	op["responses"].(gin.H)["403"] = errorResponse("wrong admin key, or the admin API is disabled")
+	return op
+}
+
+func probe(summary string, readiness bool) gin.H {
+	status := gin.H{"type": "object", "properties": gin.H{"status": gin.H{"type": "string"}}}
+	responses := gin.H{"200": jsonResponse("ok", status)}
+	if readiness {
+		status["properties"].(gin.H)["checks"] = gin.H{"type": "object", "additionalProperties": gin.H{"type": "string", "enum": []string{"ok", "timeout", "error"}}}
+		responses["503"] = jsonResponse("a check failed", status)
+	}
+	return gin.H{"summary": summary, "responses": responses}
+}
+
+func conditionalWriteResponses() gin.H {
+	return gin.H{
+		"200": userResponse("the updated user"),
+		"400": errorResponse("invalid body"),
+		"404": errorResponse("no such user"),
+		"409": errorResponse("email already in use"),
+		"412": errorResponse("If-Match doesn't match the current version"),
+		"428": errorResponse("If-Match is required (REQUIRE_IF_MATCH)"),
+	}
+}
+
+func ref(name string) gin.H { return gin.H{"$ref": "#/components/schemas/" + name} }
+
+func pathParam(name, typ, desc string) gin.H {
+	return gin.H{"name": name, "in": "path", "required": true, "schema": gin.H{"type": typ}, "description": desc}
+}
+
+func queryParam(name, typ, desc string) gin.H {
+	return gin.H{"name": name, "in": "query", "schema": gin.H{"type": typ}, "description": desc}
+}
+
+func headerParam(name, desc string) gin.H {
+	return gin.H{"name": name, "in": "header", "schema": gin.H{"type": "string"}, "description": desc}
+}
+
+func ifMatchParam() gin.H {
+	return headerParam("If-Match", "ETag of the version being changed; the write fails with 412 if the user has changed since")
+}
+
+func jsonBody(schema gin.H) gin.H {
+	return gin.H{"required": true, "content": gin.H{"application/json": gin.H{"schema": schema}}}
+}
+
+func jsonResponse(desc string, schema gin.H) gin.H {
+	return gin.H{"description": desc, "content": gin.H{"application/json": gin.H{"schema": schema}}}
+}
+
+func textResponse(desc string) gin.H {
+	return gin.H{"description": desc}
+}
+
+func userResponse(desc string) gin.H {
+	r := jsonResponse(desc, ref("User"))
+	r["headers"] = gin.H{"ETag": gin.H{"schema": gin.H{"type": "string"}, "description": "version of the user, for If-Match and If-None-Match"}}
+	return r
+}
+
+func notModified() gin.H {
+	return gin.H{"description": "If-None-Match names the current version"}
+}
+
+func errorResponse(desc string) gin.H {
+	return jsonResponse(desc, ref("Error"))
+}
+
+// schemaFor derives a JSON schema from a Go type the way encoding/json
+// sees it: json tags name the properties, pointers are nullable, and
+// binding tags (see bind.go) add required, min/max and email constraints.
+func schemaFor(t reflect.Type) gin.H {
+	if t == reflect.TypeFor[time.Time]() {
+		return gin.H{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaFor(t.Elem())
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return gin.H{"type": "object", "additionalProperties": true}
+		}
+		return gin.H{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := gin.H{}
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+				// Embedded struct: its fields are promoted, as encoding/json does
+				embedded := schemaFor(f.Type)
+				maps.Copy(props, embedded["properties"].(gin.H))
+				if r, ok := embedded["required"].([]string); ok {
+					required = append(required, r...)
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s := schemaFor(f.Type)
+			rules := parseBindingTag(f.Tag.Get("binding"))
+			rules.constrain(s, s["type"] == "string")
+			if rules.Required {
+				required = append(required, name)
+			}
+			props[name] = s
+		}
+		s := gin.H{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return gin.H{} // any JSON value
+}
+
+// checkSpecRoutes logs every route in routes that spec doesn't describe,
+// so an endpoint added without documentation is noticed at startup.
+func checkSpecRoutes(spec gin.H, routes gin.RoutesInfo) {
+	for _, route := range missingSpecRoutes(spec, routes) {
+		log.Printf("⚠️ %s is missing from /openapi.json", route)
+	}
+}
+
+// missingSpecRoutes returns "METHOD /path" for every route in routes that
+// spec doesn't describe.
+func missingSpecRoutes(spec gin.H, routes gin.RoutesInfo) []string {
+	var missing []string
+	paths := spec["paths"].(gin.H)
+	for _, rt := range routes {
+		item, _ := paths[openAPIPath(rt.Path)].(gin.H)
+		if _, ok := item[strings.ToLower(rt.Method)]; !ok {
+			missing = append(missing, rt.Method+" "+rt.Path)
+		}
+	}
+	return missing
+}
+
+// openAPIPath turns a Gin route path (/users/:id) into OpenAPI form (/users/{id}).
+func openAPIPath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		if len(s) > 0 && (s[0] == ':' || s[0] == '*') {
+			segs[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Every route NewRouter registers must be described in /openapi.json.
+func TestSpecCoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Routes that need the database (admin webhooks, Idempotency-Key) are
+	// only registered with a pool; this one never connects
+	db, err := pgxpool.New(context.Background(), cfg.DBURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r := NewRouter(RouterDeps{DB: db, Config: cfg})
+	for _, route := range missingSpecRoutes(openAPISpec(cfg), r.Routes()) {
+		t.Errorf("%s is missing from /openapi.json", route)
+	}
+}
+
+func TestMissingSpecRoutes(t *testing.T) {
+	spec := gin.H{"paths": gin.H{
+		"/users/{id}": gin.H{"get": gin.H{}},
+	}}
+	routes := gin.RoutesInfo{
+		{Method: "GET", Path: "/users/:id"},
+		{Method: "DELETE", Path: "/users/:id"},
+		{Method: "GET", Path: "/undocumented"},
+	}
+	got := missingSpecRoutes(spec, routes)
+	want := []string{"DELETE /users/:id", "GET /undocumented"}
+	if len(got) != len(want) {
+		t.Fatalf("missingSpecRoutes = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("missingSpecRoutes[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
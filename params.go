@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxUserID is the largest id a user can have: users.id is a SERIAL (int4).
+// Larger ids can't exist, and pgx refuses to send them as int4 anyway.
+const maxUserID = math.MaxInt32
+
+// errInvalidPathParam is returned when a by-value path param can't be used safely.
+var errInvalidPathParam = errors.New("invalid path parameter")
+
+// pathValue returns the decoded value of a by-value path param (email, username, ...).
+//
+// The router runs with UseRawPath=true and UnescapePathValues=false, so the raw
+// param still holds its percent-encoding. We decode it exactly once here:
+//   - "%40" becomes "@", but "+" stays a literal plus (path, not query, semantics)
+//   - an encoded slash ("%2F") is rejected instead of silently becoming a separator
+//   - control characters are rejected
+//   - the result is NFC-normalized so composed/decomposed unicode compare equal
+func pathValue(c *gin.Context, name string) (string, error) {
+	v := c.Param(name)
+
+	// net/url leaves RawPath empty when it would match the canonical
+	// encoding of Path, and gin then routes on Path, already decoded
+	// ("%2540" arrives as "%40"): decoding again would turn it into "@".
+	if c.Request.URL.RawPath != "" {
+		var err error
+		if v, err = url.PathUnescape(v); err != nil {
+			return "", errInvalidPathParam
+		}
+	}
+	if v == "" || strings.Contains(v, "/") {
+		return "", errInvalidPathParam
+	}
+	for _, r := range v {
+		if unicode.IsControl(r) {
+			return "", errInvalidPathParam
+		}
+	}
+	return norm.NFC.String(v), nil
+}
+
+// userID parses the :id path param as a positive integer.
+// On failure it writes a 400 and returns false, so callers can just return.
+// An id too large to exist (see maxUserID) is a 404, like any other
+// missing user.
+func userID(c *gin.Context) (int, bool) {
+	raw := c.Param("id")
+	id, err := strconv.ParseInt(raw, 10, 32)
+	if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(raw, "-") {
+		respondError(c, http.StatusNotFound, gin.H{"error": "user not found"})
+		return 0, false
+	}
+	if err != nil || id <= 0 {
+		respondError(c, http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, false
+	}
+	return int(id), true
+}
+
+// batchIDs enforces maxIDs (MAX_BATCH_IDS) on an id list from a request body and
+// returns it without duplicates, in first-seen order. Ids above maxUserID
+// are left out: no such user exists, so they count as not found. On
+// failure it writes a 400 in the {"errors": ...} shape and returns false.
+func batchIDs(c *gin.Context, field string, ids []int, maxIDs int) ([]int, bool) {
+	if len(ids) > maxIDs {
+		respondError(c, http.StatusBadRequest, gin.H{"errors": gin.H{field: "must be at most " + strconv.Itoa(maxIDs) + " items"}})
+		return nil, false
+	}
+	seen := make(map[int]bool, len(ids))
+	out := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] && id <= maxUserID {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, true
+}
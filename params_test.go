@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+// By-value lookups decode their path param exactly once (see pathValue).
+func TestPathValueLookups(t *testing.T) {
+	repo := newFakeRepo(
+		User{Name: "Ada", Email: "ada@example.com"},
+		User{Name: "Ada News", Email: "ada+news@example.com"},
+		User{Name: "Unicorn", Email: "🦄@example.com"},
+		User{Name: "Café", Email: "café@example.com"}, // composed é
+	)
+	r := testRouter(t, repo, testConfig(t))
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		id     float64
+	}{
+		{"plain", "/users/by-email/ada@example.com", 200, 1},
+		{"plus addressing", "/users/by-email/ada+news@example.com", 200, 2},
+		{"encoded plus stays a plus", "/users/by-email/ada%2Bnews@example.com", 200, 2},
+		{"encoded at sign", "/users/by-email/ada%40example.com", 200, 1},
+		{"emoji", "/users/by-email/%F0%9F%A6%84%40example.com", 200, 3},
+		{"decomposed unicode", "/users/by-email/cafe%CC%81@example.com", 200, 4},
+		{"encoded slash", "/users/by-email/ada%2F..%2Fadmin@example.com", 400, 0},
+		{"double encoded at sign", "/users/by-email/ada%2540example.com", 400, 0},
+		{"nul byte", "/users/by-email/ada%00@example.com", 400, 0},
+		{"newline", "/users/by-email/ada%0A@example.com", 400, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(r, "GET", tc.path, "")
+			if w.Code != tc.status {
+				t.Fatalf("GET %s = %d, want %d\n%s", tc.path, w.Code, tc.status, w.Body)
+			}
+			if tc.status == 200 {
+				if id := jsonObject(t, w)["id"]; id != tc.id {
+					t.Errorf("GET %s found user %v, want %v", tc.path, id, tc.id)
+				}
+			}
+		})
+	}
+}
+
+// Ids that don't fit users.id (int4) can't exist: 404, not a failed query.
+func TestUserIDRange(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/users/2147483647", 404},
+		{"/users/2147483648", 404},
+		{"/users/99999999999999999999", 404},
+		{"/users/0", 400},
+		{"/users/-1", 400},
+		{"/users/-99999999999", 400},
+		{"/users/1e3", 400},
+	}
+	for _, tc := range tests {
+		if w := serve(r, "GET", tc.path, ""); w.Code != tc.status {
+			t.Errorf("GET %s = %d, want %d", tc.path, w.Code, tc.status)
+		}
+	}
+}
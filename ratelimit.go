@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// Per-client rate limiting, keyed by c.ClientIP(), which only honors
+// X-Forwarded-For from TRUSTED_PROXIES (see config.go).
+//
+//	RATE_LIMIT        sustained requests per second per client (default 100, 0 disables)
+//	RATE_LIMIT_BURST  bucket size, i.e. how many requests may arrive at once (default 200)
+
+// rateLimitIdle is how long a client's bucket is kept after its last request.
+// By then it has refilled completely, so dropping it changes nothing.
+const rateLimitIdle = 5 * time.Minute
+
+// clientLimiter is one client's token bucket.
+type clientLimiter struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds a token bucket per client IP.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+// newRateLimiter returns middleware allowing each client limit requests per
+// second in bursts of up to burst (RATE_LIMIT, RATE_LIMIT_BURST), or nil
+// when rate limiting is disabled. Every response carries
+// X-RateLimit-Limit and X-RateLimit-Remaining; a 429 adds Retry-After.
+func newRateLimiter(limit, burst int) gin.HandlerFunc {
+	if limit <= 0 {
+		return nil
+	}
+	rl := &rateLimiter{limit: rate.Limit(limit), burst: burst, clients: map[string]*clientLimiter{}, lastSweep: time.Now()}
+	return rl.handle
+}
+
+func (rl *rateLimiter) handle(c *gin.Context) {
+	// Probes must keep working while a client is being throttled
+	if probeRoutes[c.FullPath()] {
+		c.Next()
+		return
+	}
+
+	now := time.Now()
+	lim := rl.limiter(c.ClientIP(), now)
+	res := lim.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay > 0 {
+		res.CancelAt(now) // a rejected request doesn't spend a token
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(rl.burst))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(max(int(lim.TokensAt(now)), 0)))
+	if delay > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		abortError(c, http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+		return
+	}
+	c.Next()
+}
+
+// limiter returns the bucket for ip, creating it on first use. Idle buckets
+// are swept at most once a minute, so memory tracks active clients only.
+func (rl *rateLimiter) limiter(ip string, now time.Time) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.lastSweep) > time.Minute {
+		for k, cl := range rl.clients {
+			if now.Sub(cl.lastSeen) > rateLimitIdle {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	cl, ok := rl.clients[ip]
+	if !ok {
+		cl = &clientLimiter{lim: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[ip] = cl
+	}
+	cl.lastSeen = now
+	return cl.lim
+}
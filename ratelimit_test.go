@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimit(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.RateLimit = 1
+	cfg.RateLimitBurst = 2
+	r := testRouter(t, seedUsers(), cfg)
+
+	// The burst goes through, counting down; the next request is refused
+	for i, want := range []struct {
+		status           int
+		remaining, retry string
+	}{
+		{200, "1", ""},
+		{200, "0", ""},
+		{429, "0", "1"},
+		{429, "0", "1"},
+	} {
+		w := serve(r, "GET", "/users", "")
+		if w.Code != want.status {
+			t.Fatalf("request %d = %d, want %d", i+1, w.Code, want.status)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 2", i+1, got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != want.remaining {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %q", i+1, got, want.remaining)
+		}
+		if got := w.Header().Get("Retry-After"); got != want.retry {
+			t.Errorf("request %d: Retry-After = %q, want %q", i+1, got, want.retry)
+		}
+	}
+	if body := jsonObject(t, serve(r, "GET", "/users", "")); body["error"] != "rate limit exceeded" {
+		t.Errorf("429 body = %v", body)
+	}
+
+	// Probes are never limited
+	for _, path := range []string{"/healthz", "/health/live"} {
+		if w := serve(r, "GET", path, ""); w.Code != 200 || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("GET %s = %d with limit headers %v, want an unlimited 200", path, w.Code, w.Header())
+		}
+	}
+}
+
+// Clients behind a trusted proxy get a bucket each; X-Forwarded-For from
+// anyone else is ignored, so it can't be used to dodge the limit.
+func TestRateLimitForwardedFor(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		proxies []string
+		second  int // status of another client's first request
+	}{
+		{"trusted proxy", []string{"192.0.2.1"}, 200},
+		{"untrusted", nil, 429},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.RateLimit = 1
+			cfg.RateLimitBurst = 1
+			cfg.TrustedProxies = tc.proxies
+			r := testRouter(t, seedUsers(), cfg)
+
+			if w := serve(r, "GET", "/users", "", "X-Forwarded-For", "203.0.113.7"); w.Code != 200 {
+				t.Fatalf("first client = %d, want 200", w.Code)
+			}
+			if w := serve(r, "GET", "/users", "", "X-Forwarded-For", "203.0.113.7"); w.Code != 429 {
+				t.Fatalf("first client again = %d, want 429", w.Code)
+			}
+			if w := serve(r, "GET", "/users", "", "X-Forwarded-For", "203.0.113.8"); w.Code != tc.second {
+				t.Errorf("second client = %d, want %d", w.Code, tc.second)
+			}
+		})
+	}
+}
+
+// Buckets idle past rateLimitIdle are dropped on the next sweep.
+func TestRateLimiterEviction(t *testing.T) {
+	start := time.Now()
+	rl := &rateLimiter{limit: rate.Limit(1), burst: 1, clients: map[string]*clientLimiter{}, lastSweep: start}
+	rl.limiter("198.51.100.1", start)
+	rl.limiter("198.51.100.2", start.Add(rateLimitIdle))
+
+	rl.limiter("198.51.100.3", start.Add(rateLimitIdle+2*time.Minute))
+	if _, ok := rl.clients["198.51.100.1"]; ok {
+		t.Error("idle client was not evicted")
+	}
+	if len(rl.clients) != 2 {
+		t.Errorf("%d clients tracked, want 2", len(rl.clients))
+	}
+}
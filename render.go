@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListResponse is the envelope every list endpoint returns:
+//
+//	{"items": [...], "total": 42, "limit": 10, "offset": 0, "order": "asc"}
+//
+// Total, Limit, Offset and Order are left out where they don't apply (no
+// total in keyset pagination, for example). Endpoints with more metadata
+// embed ListResponse in their own struct, so the extra fields sit next to
+// these. Single resources aren't wrapped: they are the object itself (for
+// users, with an ETag; see respondUser).
+//
+// Handlers must finish reading their rows before responding, so a scan
+// error can still produce a clean error response instead of a second body.
+type ListResponse[T any] struct {
+	Items  []T    `json:"items"`            // never null
+	Total  *int   `json:"total,omitempty"`  // matching items across all pages
+	Limit  int    `json:"limit,omitempty"`  // page size
+	Offset *int   `json:"offset,omitempty"` // items skipped before this page
+	Order  string `json:"order,omitempty"`  // "asc" or "desc"
+}
+
+// newList returns a ListResponse of items, rendering a nil slice as []
+// rather than null so clients can always iterate it.
+func newList[T any](items []T) ListResponse[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return ListResponse[T]{Items: items}
+}
+
+// respondError writes an error body with the request ID added, so a client
+// can quote it when reporting the failure. Every error response goes
+// through here (or abortError). Errors are never cached, whatever the
+// route's Cache-Control (see cache.go).
+func respondError(c *gin.Context, status int, body gin.H) {
+	body["request_id"] = requestIDFrom(c.Request.Context())
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, body)
+}
+
+// abortError is respondError for middleware: it also stops the chain.
+func abortError(c *gin.Context, status int, body gin.H) {
+	c.Abort()
+	respondError(c, status, body)
+}
+
+// respondInternalError sends a generic 500. The real error is attached to
+// the context for the request log and never shown to the client.
+// Errors caused by the request deadline (see withTimeout) or by Postgres'
+// statement_timeout become a 504.
+func respondInternalError(c *gin.Context, err error) {
+	c.Error(err)
+	if errors.Is(err, context.DeadlineExceeded) || isQueryCanceled(err) {
+		respondError(c, http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+		return
+	}
+	respondError(c, http.StatusInternalServerError, gin.H{"error": "internal server error"})
+}
+
+// arrayStream writes a JSON array one element at a time, for collections too
+// big to buffer. The status (200) and headers go out with the first byte, so
+// list metadata must be sent as headers, and a failure part-way through can
+// only be reported in the X-Stream-Error trailer: Close still terminates the
+// array so the body stays valid JSON.
+type arrayStream struct {
+	c       *gin.Context
+	enc     *json.Encoder
+	n       int
+	started bool
+}
+
+// newArrayStream prepares c for streaming. Extra trailer names (set with
+// c.Writer.Header().Set after the body) must be declared here, up front.
+func newArrayStream(c *gin.Context, trailers ...string) *arrayStream {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Header("Trailer", strings.Join(append([]string{"X-Stream-Error"}, trailers...), ", "))
+	return &arrayStream{c: c, enc: json.NewEncoder(c.Writer)}
+}
+
+// Started reports whether anything has been written yet. Until then the
+// caller may still send an ordinary error response instead.
+func (s *arrayStream) Started() bool {
+	return s.started
+}
+
+func (s *arrayStream) begin() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.c.Status(http.StatusOK)
+	s.c.Writer.WriteString("[")
+}
+
+// Write appends one element, flushing every streamFlushEvery elements.
+func (s *arrayStream) Write(item any) error {
+	s.begin()
+	if s.n > 0 {
+		if _, err := s.c.Writer.WriteString(","); err != nil {
+			return err
+		}
+	}
+	if err := s.enc.Encode(item); err != nil {
+		return err
+	}
+	if s.n++; s.n%streamFlushEvery == 0 {
+		s.c.Writer.Flush()
+	}
+	return nil
+}
+
+// Close ends the array. A non-nil err is logged and sent as the
+// X-Stream-Error trailer, since the status line is long gone.
+func (s *arrayStream) Close(err error) {
+	s.begin()
+	s.c.Writer.WriteString("]")
+	if err != nil {
+		logFor(s.c).Error("stream aborted", "method", s.c.Request.Method, "path", s.c.Request.URL.Path, "items", s.n, "error", err)
+		s.c.Writer.Header().Set("X-Stream-Error", "stream aborted")
+	}
+	s.c.Writer.Flush()
+}
+
+// streamFlushEvery is how many elements arrayStream buffers between flushes.
+const streamFlushEvery = 32
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// An empty result is "items": [], never null, wherever the list comes from.
+func TestEmptyListItems(t *testing.T) {
+	for _, target := range []string{
+		"/users?q=nomatch",
+		"/users?metadata.team=none",
+		"/users?created_after=2030-01-01T00:00:00Z",
+		"/users?offset=50",
+	} {
+		r := testRouter(t, seedUsers(), testConfig(t))
+		w := serve(r, "GET", target, "")
+		if w.Code != 200 {
+			t.Fatalf("GET %s = %d, want 200\n%s", target, w.Code, w.Body)
+		}
+		if !strings.Contains(w.Body.String(), `"items":[]`) {
+			t.Errorf("GET %s: body has no empty items array: %s", target, w.Body)
+		}
+	}
+}
+
+func TestNewListNil(t *testing.T) {
+	b, err := json.Marshal(newList[User](nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"items":[]}` {
+		t.Errorf("newList(nil) = %s, want {\"items\":[]}", b)
+	}
+}
+
+// A failing query is one error body, not a partial list followed by it.
+func TestListErrorSingleBody(t *testing.T) {
+	repo := seedUsers()
+	repo.err = errors.New("scan failed")
+	w := serve(testRouter(t, repo, testConfig(t)), "GET", "/users", "")
+	if w.Code != 500 {
+		t.Fatalf("status = %d, want 500\n%s", w.Code, w.Body)
+	}
+	dec := json.NewDecoder(w.Body)
+	var body map[string]any
+	if err := dec.Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["items"]; ok || dec.More() {
+		t.Errorf("error response carries list output: %v", body)
+	}
+}
@@ -0,0 +1,891 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// User struct maps directly to the "users" table in Postgres.
+// The JSON tags control how the struct is serialized/deserialized in API responses.
+type User struct {
+	ID        int            `json:"id"`                   // primary key
+	Name      string         `json:"name"`                 // user name
+	Email     string         `json:"email"`                // unique email
+	Metadata  map[string]any `json:"metadata"`             // free-form flat attributes (jsonb)
+	CreatedAt time.Time      `json:"created_at"`           // timestamp when user was created
+	UpdatedAt time.Time      `json:"updated_at"`           // timestamp when user was last updated
+	DeletedAt *time.Time     `json:"deleted_at,omitempty"` // set when soft-deleted; only visible to admins
+
+	// Counters, read-only here; see IncrementCounters
+	LoginCount       int64 `json:"login_count"`
+	ProfileViewCount int64 `json:"profile_view_count"`
+}
+
+// userColumns is the column list matching User.scanFields, in order.
+const userColumns = "id, name, email, metadata, created_at, updated_at, deleted_at, login_count, profile_view_count"
+
+// userFields are the columns of userColumns one by one. Each is also the
+// User's JSON field name, which is what ?fields= selects by.
+var userFields = strings.Split(userColumns, ", ")
+
+// notDeleted is the predicate that hides soft-deleted users. Every query that
+// serves or changes live users must include it.
+const notDeleted = "deleted_at IS NULL"
+
+// scanFields returns pointers to u's fields in userColumns order for rows.Scan.
+func (u *User) scanFields() []any {
+	return []any{&u.ID, &u.Name, &u.Email, &u.Metadata, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.LoginCount, &u.ProfileViewCount}
+}
+
+// columnsFor returns the userFields named in fields, in userColumns order
+// and always with id; no fields means all of them. Other names are ignored.
+func columnsFor(fields []string) []string {
+	if len(fields) == 0 {
+		return userFields
+	}
+	var cols []string
+	for _, col := range userFields {
+		if col == "id" || slices.Contains(fields, col) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// scanFieldsFor is scanFields for just the given columns (see columnsFor).
+// The fields of other columns are left zero.
+func (u *User) scanFieldsFor(cols []string) []any {
+	all := u.scanFields()
+	var dest []any
+	for i, col := range userFields {
+		if slices.Contains(cols, col) {
+			dest = append(dest, all[i])
+		}
+	}
+	return dest
+}
+
+// Errors returned by UserRepository. Handlers map them to status codes;
+// anything else is an unexpected failure (500).
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken   = errors.New("email already in use")
+	// ErrVersionMismatch means the user changed since the version the
+	// caller's write was conditional on (IfUpdatedAt).
+	ErrVersionMismatch = errors.New("user was modified")
+	// ErrCounterNegative means an IncrementCounters call would have taken a
+	// counter below zero; nothing was changed.
+	ErrCounterNegative = errors.New("counter would go below zero")
+	// ErrCounterOverflow means an IncrementCounters call would have taken a
+	// counter past the largest BIGINT; nothing was changed.
+	ErrCounterOverflow = errors.New("counter would overflow")
+)
+
+// DeletedEmailError is returned instead of a plain ErrEmailTaken when the
+// email belongs to a soft-deleted user, who can be restored rather than
+// re-created. errors.Is(err, ErrEmailTaken) still holds.
+type DeletedEmailError struct {
+	UserID int
+}
+
+func (e *DeletedEmailError) Error() string {
+	return fmt.Sprintf("email belongs to deleted user %d", e.UserID)
+}
+
+func (e *DeletedEmailError) Is(target error) bool { return target == ErrEmailTaken }
+
+// BatchError reports which input of CreateMany failed. Err is the
+// repository error for that row (e.g. ErrEmailTaken).
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string { return fmt.Sprintf("item %d: %v", e.Index, e.Err) }
+
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// UserFilter selects and orders a page of users. Values are assumed to be
+// validated by the caller; Sort must be a column or an allowlisted metadata.<key>.
+type UserFilter struct {
+	Query    string            // ILIKE search over name and email
+	Prefix   bool              // match Query only at the start (cheaper than a substring match)
+	Metadata map[string]string // metadata key -> exact text value
+
+	CreatedAfter  *time.Time // created_at >= this
+	CreatedBefore *time.Time // created_at < this
+
+	Sort   []SortKey // at least one; id breaks remaining ties, in the first key's direction
+	Limit  int       // 0 means no limit (exports); the page then has no Total
+	Offset int
+	After  *Cursor // keyset mode: rows strictly after this position; Offset is ignored
+
+	// MaxID, when set, hides users with a higher id, i.e. those created after
+	// it was taken (see UserRepository.MaxID). Carried in cursors, it keeps a
+	// paginated listing from shifting as users are added.
+	MaxID int
+
+	IncludeDeleted bool // also return soft-deleted users
+
+	// Fields limits the columns fetched to these userFields (id and the
+	// sort columns are always fetched); nil fetches all of them. The
+	// other User fields are left zero.
+	Fields []string
+}
+
+// SortKey is one column of a listing's sort order.
+type SortKey struct {
+	Column string `json:"column"` // a column, or metadata.<key> for allowlisted keys
+	Order  string `json:"order"`  // "asc" or "desc"
+}
+
+// UserPage is one page of a listing.
+type UserPage struct {
+	Items   []User
+	Total   *int // matching rows across all pages; nil in keyset mode, where counting would defeat the point
+	HasMore bool // whether rows exist past this page
+}
+
+// UserInput carries the writable fields for create and full update.
+// EmailKey is emailKey(Email). A nil Metadata means "not provided".
+type UserInput struct {
+	Name     string
+	Email    string
+	EmailKey string
+	Metadata map[string]any
+
+	// IfUpdatedAt makes an update conditional: it applies only if the row's
+	// updated_at still equals it, otherwise ErrVersionMismatch. Ignored by Create.
+	IfUpdatedAt *time.Time
+}
+
+// UserPatch carries a partial update; nil fields are left unchanged.
+// EmailKey must be set whenever Email is.
+type UserPatch struct {
+	Name     *string
+	Email    *string
+	EmailKey *string
+	Metadata map[string]any
+
+	IfUpdatedAt *time.Time // as in UserInput
+}
+
+// UserRepository is the storage behind the /users endpoints.
+type UserRepository interface {
+	// List returns one page of users matching the filter.
+	List(ctx context.Context, f UserFilter) (UserPage, error)
+	// Each is List without buffering: fn sees each row as it is scanned,
+	// with the page total (nil in keyset mode). The returned page has no Items.
+	Each(ctx context.Context, f UserFilter, fn func(u User, total *int) error) (UserPage, error)
+	// MaxID returns the highest user id assigned so far (0 for none), the
+	// bound of a snapshot listing (UserFilter.MaxID).
+	MaxID(ctx context.Context) (int, error)
+	// EstimateRows returns the planner's row estimate for the filtered set,
+	// without running the query.
+	EstimateRows(ctx context.Context, f UserFilter) (int, error)
+	// Facets returns bucket counts for each named facet over the filtered set.
+	Facets(ctx context.Context, f UserFilter, names []string) (map[string]Facet, error)
+	// Get returns a live user. Given fields, only those columns (and id)
+	// are fetched, as with UserFilter.Fields.
+	Get(ctx context.Context, id int, fields ...string) (User, error)
+	// GetByEmail looks a user up by email key (see emailKey).
+	GetByEmail(ctx context.Context, key string) (User, error)
+	Create(ctx context.Context, in UserInput) (User, error)
+	// PreviewCreate runs Create's insert and rolls it back, returning the row
+	// that would have been stored. The previewed id is consumed (sequences
+	// don't roll back), so the real create gets a different one.
+	PreviewCreate(ctx context.Context, in UserInput) (User, error)
+	// CreateMany inserts users in one transaction and returns them in input
+	// order. The first failing row rolls everything back and comes back as a
+	// *BatchError. With skipDuplicates, rows whose email is taken are left
+	// out instead and their slot in the result is nil.
+	CreateMany(ctx context.Context, ins []UserInput, skipDuplicates bool) ([]*User, error)
+	Update(ctx context.Context, id int, in UserInput) (User, error)
+	Patch(ctx context.Context, id int, p UserPatch) (User, error)
+	// Delete soft-deletes a user; deleting one twice is ErrUserNotFound.
+	Delete(ctx context.Context, id int) error
+	// Restore undoes Delete. It returns ErrUserNotFound unless the user
+	// exists and is deleted.
+	Restore(ctx context.Context, id int) (User, error)
+	// Touch bumps updated_at on the given users without changing any data
+	// and returns how many existed.
+	Touch(ctx context.Context, ids []int) (int, error)
+	// IncrementCounters adds each delta to the named counter (one of
+	// userCounters) atomically and returns the user. Deltas may be
+	// negative, but if any counter would go below zero (ErrCounterNegative)
+	// or overflow (ErrCounterOverflow) none change.
+	IncrementCounters(ctx context.Context, id int, deltas map[string]int64) (User, error)
+}
+
+// pgUserRepository implements UserRepository on a pgx pool.
+// Every mutation queues its webhook in the same transaction (see webhook.go).
+type pgUserRepository struct {
+	db             *pgxpool.Pool
+	webhookURLs    []string // subscribers notified of every change (see webhook.go)
+	touchUpdatedAt bool     // counter changes bump updated_at (see counters.go)
+}
+
+// NewUserRepository returns a Postgres-backed UserRepository configured by cfg.
+func NewUserRepository(db *pgxpool.Pool, cfg Config) UserRepository {
+	return &pgUserRepository{db: db, webhookURLs: cfg.WebhookURLs, touchUpdatedAt: cfg.CountersTouchUpdatedAt}
+}
+
+func (r *pgUserRepository) List(ctx context.Context, f UserFilter) (UserPage, error) {
+	var items []User
+	page, err := r.Each(ctx, f, func(u User, _ *int) error {
+		items = append(items, u)
+		return nil
+	})
+	page.Items = items
+	return page, err
+}
+
+// listQuery is the SQL Each runs for a UserFilter.
+type listQuery struct {
+	sql  string
+	args []any
+	cols []string // columns selected before the total
+
+	// where and whereArgs are the filter alone, without the keyset
+	// predicate, for the fallback COUNT
+	where     string
+	whereArgs []any
+}
+
+// buildListQuery returns the page query for f. Only allowlisted names
+// become SQL text; every value is a bound argument, so the text depends on
+// which filters are used, never on their values.
+func buildListQuery(f UserFilter) (listQuery, error) {
+	// In offset mode count(*) OVER() gives the total matching rows in the
+	// same round trip. Keyset mode skips it: counting scans everything.
+	// Unlimited reads skip it too, so rows start flowing before the end.
+	// With Fields, the sort columns are fetched too: cursors need them.
+	cols := userFields
+	if f.Fields != nil {
+		fields := slices.Clone(f.Fields)
+		for _, k := range f.Sort {
+			fields = append(fields, k.Column)
+		}
+		cols = columnsFor(fields)
+	}
+	selectList := strings.Join(cols, ", ")
+	query := "SELECT " + selectList + ", count(*) OVER() AS total FROM users "
+	if f.After != nil || f.Limit <= 0 {
+		query = "SELECT " + selectList + ", NULL::bigint AS total FROM users "
+	}
+	conds, args := filterConds(f)
+	where, whereArgs := whereClause(conds), slices.Clip(args)
+
+	// Keyset predicate: rows after (sort value, id) in the requested direction.
+	// Keyset mode sorts by a single plain column (see Cursor).
+	// It's kept out of `where` so the fallback COUNT in Each ignores it.
+	if f.After != nil {
+		sort := f.Sort[0]
+		cmp := ">"
+		if sort.Order == "desc" {
+			cmp = "<"
+		}
+		if sort.Column == "id" {
+			args = append(args, f.After.ID)
+			conds = append(conds, fmt.Sprintf("id %s $%d", cmp, len(args)))
+		} else {
+			args = append(args, f.After.Value, f.After.ID)
+			conds = append(conds, fmt.Sprintf("(%s, id) %s ($%d, $%d)", sort.Column, cmp, len(args)-1, len(args)))
+		}
+		query += whereClause(conds)
+	} else {
+		query += where
+	}
+
+	// ORDER BY + LIMIT/OFFSET. Only allowlisted names are spliced into the
+	// SQL; the numbers are bound like any other value.
+	order, err := orderBy(f.Sort)
+	if err != nil {
+		return listQuery{}, err
+	}
+	query += order
+	offset := f.Offset
+	if f.After != nil {
+		offset = 0
+	}
+	queryArgs := args
+	if f.Limit > 0 {
+		// One extra row tells us whether there's a next page
+		queryArgs = append(queryArgs, f.Limit+1)
+		query += fmt.Sprintf("LIMIT $%d ", len(queryArgs))
+	}
+	queryArgs = append(queryArgs, offset)
+	query += fmt.Sprintf("OFFSET $%d", len(queryArgs))
+	return listQuery{sql: query, args: queryArgs, cols: cols, where: where, whereArgs: whereArgs}, nil
+}
+
+// Each runs the List query and calls fn for every row of the page as it is
+// scanned, without buffering. total is the same for every call (nil in keyset
+// mode). The returned page has no Items. An error from fn stops the scan and
+// is returned as-is.
+func (r *pgUserRepository) Each(ctx context.Context, f UserFilter, fn func(u User, total *int) error) (UserPage, error) {
+	q, err := buildListQuery(f)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	// --- Execute query ---
+	// An unlimited read (an export) streams for as long as the data takes,
+	// which can be well past DB_STATEMENT_TIMEOUT; the request deadline
+	// (EXPORT_TIMEOUT on the export routes) bounds it instead. SET LOCAL
+	// needs a transaction.
+	var db interface {
+		Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	} = r.db
+	if f.Limit <= 0 {
+		tx, err := r.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return UserPage{}, err
+		}
+		defer tx.Rollback(context.WithoutCancel(ctx))
+		if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+			return UserPage{}, err
+		}
+		db = tx
+	}
+	rows, err := db.Query(ctx, q.sql, q.args...)
+	if err != nil {
+		return UserPage{}, err
+	}
+	defer rows.Close()
+
+	var page UserPage
+	var total *int
+	seen := 0
+	for rows.Next() {
+		// The extra (limit+1)th row only signals that there's more
+		if seen++; f.Limit > 0 && seen > f.Limit {
+			page.HasMore = true
+			break
+		}
+		var u User
+		if err := rows.Scan(append(u.scanFieldsFor(q.cols), &total)...); err != nil {
+			return UserPage{}, err
+		}
+		if err := fn(u, total); err != nil {
+			return UserPage{}, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return UserPage{}, err
+	}
+
+	if f.After == nil && f.Limit > 0 {
+		// Past the last page the window count has no row to ride on,
+		// so fall back to a plain COUNT with the same filter and args.
+		if total == nil {
+			n := 0
+			if f.Offset > 0 {
+				if err := r.db.QueryRow(ctx, "SELECT count(*) FROM users "+q.where, q.whereArgs...).Scan(&n); err != nil {
+					return UserPage{}, err
+				}
+			}
+			total = &n
+		}
+		page.Total = total
+	}
+	return page, nil
+}
+
+func (r *pgUserRepository) MaxID(ctx context.Context) (int, error) {
+	var id int
+	err := r.db.QueryRow(ctx, "SELECT coalesce(max(id), 0) FROM users").Scan(&id)
+	return id, err
+}
+
+func (r *pgUserRepository) EstimateRows(ctx context.Context, f UserFilter) (int, error) {
+	conds, args := filterConds(f)
+	var out string
+	err := r.db.QueryRow(ctx, "EXPLAIN (FORMAT JSON) SELECT 1 FROM users "+whereClause(conds), args...).Scan(&out)
+	if err != nil {
+		return 0, err
+	}
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		}
+	}
+	if err := json.Unmarshal([]byte(out), &plans); err != nil || len(plans) == 0 {
+		return 0, fmt.Errorf("unexpected EXPLAIN output: %v", err)
+	}
+	return int(plans[0].Plan.Rows), nil
+}
+
+// Facets counts users per bucket of each named facet over the whole filtered
+// set (pagination is ignored). Each facet keeps its top facetBuckets buckets;
+// the rest are summed into Other. Names must come from userFacets.
+func (r *pgUserRepository) Facets(ctx context.Context, f UserFilter, names []string) (map[string]Facet, error) {
+	conds, args := filterConds(f)
+	where := whereClause(conds)
+
+	out := make(map[string]Facet, len(names))
+	for _, name := range names {
+		// Rank buckets by size; everything past the top N collapses into one
+		// row with top false, so a NULL value among the top N stays its own
+		rows, err := r.db.Query(ctx, fmt.Sprintf(
+			`SELECT bucket, n, top FROM (
+			   SELECT rn <= %[1]d AS top, CASE WHEN rn <= %[1]d THEN v END AS bucket, sum(n)::bigint AS n
+			   FROM (
+			     SELECT v, n, row_number() OVER (ORDER BY n DESC, v) AS rn
+			     FROM (SELECT %[2]s AS v, count(*) AS n FROM users %[3]s GROUP BY 1) g
+			   ) ranked
+			   GROUP BY 1, 2
+			 ) t
+			 ORDER BY NOT top, n DESC, bucket`,
+			facetBuckets, userFacets[name], where), args...)
+		if err != nil {
+			return nil, err
+		}
+		buckets, err := pgx.CollectRows(rows, pgx.RowToStructByPos[struct {
+			Value *string
+			Count int
+			Top   bool
+		}])
+		if err != nil {
+			return nil, err
+		}
+
+		facet := Facet{Buckets: []FacetBucket{}}
+		for _, b := range buckets {
+			if b.Top {
+				facet.Buckets = append(facet.Buckets, FacetBucket{Value: b.Value, Count: b.Count})
+			} else {
+				facet.Other = b.Count
+			}
+		}
+		out[name] = facet
+	}
+	return out, nil
+}
+
+// likeEscaper escapes the LIKE wildcards (and the escape character itself).
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match itself literally inside a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// filterConds builds the WHERE conditions (and their args, numbered from $1)
+// shared by listings, counts and facets.
+func filterConds(f UserFilter) ([]string, []any) {
+	var conds []string
+	var args []any
+	if !f.IncludeDeleted {
+		conds = append(conds, notDeleted)
+	}
+	if f.Query != "" {
+		// Case-insensitive; q matches literally, so its % and _ are escaped.
+		// The prefix form is written against lower(col) so it can use the
+		// text_pattern_ops indexes from migration 0008.
+		if f.Prefix {
+			args = append(args, escapeLike(f.Query)+"%")
+			conds = append(conds, fmt.Sprintf(
+				`(lower(name) LIKE lower($%d) ESCAPE '\' OR lower(email) LIKE lower($%d) ESCAPE '\')`, len(args), len(args)))
+		} else {
+			args = append(args, "%"+escapeLike(f.Query)+"%")
+			conds = append(conds, fmt.Sprintf(
+				`(name ILIKE $%d ESCAPE '\' OR email ILIKE $%d ESCAPE '\')`, len(args), len(args)))
+		}
+	}
+
+	if f.MaxID > 0 {
+		args = append(args, f.MaxID)
+		conds = append(conds, fmt.Sprintf("id <= $%d", len(args)))
+	}
+
+	if f.CreatedAfter != nil {
+		args = append(args, *f.CreatedAfter)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if f.CreatedBefore != nil {
+		args = append(args, *f.CreatedBefore)
+		conds = append(conds, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	// metadata filters compare a top-level key as text; keys are sorted so
+	// the same filter set always produces the same SQL
+	for _, key := range slices.Sorted(maps.Keys(f.Metadata)) {
+		args = append(args, key, f.Metadata[key])
+		conds = append(conds, fmt.Sprintf("metadata->>($%d::text) = $%d", len(args)-1, len(args)))
+	}
+	return conds, args
+}
+
+// sortColumns are the columns a listing can sort by, besides metadata.<key>
+// for allowlisted keys (see metadataSortKeys).
+var sortColumns = map[string]bool{"id": true, "name": true, "email": true, "created_at": true, "updated_at": true}
+
+// orderBy renders sort keys as "ORDER BY ... ", with id as the final
+// tie-breaker so pages are deterministic. Metadata values may be missing,
+// so their NULLs go last.
+//
+// Identifiers can't be bound as parameters, so this is the one place user
+// choices become SQL text. It re-checks every column against the allowlist,
+// and metadata keys (already checked against METADATA_SORT_KEYS by
+// parseSort) against metadataKeyPattern, and emits only the fixed words ASC
+// and DESC, whatever the caller passed.
+func orderBy(keys []SortKey) (string, error) {
+	if len(keys) == 0 {
+		keys = []SortKey{{Column: "id", Order: "asc"}}
+	}
+	dir := func(k SortKey) string {
+		if k.Order == "desc" {
+			return "DESC"
+		}
+		return "ASC"
+	}
+	parts := make([]string, 0, len(keys)+1)
+	hasID := false
+	for _, k := range keys {
+		if key, ok := strings.CutPrefix(k.Column, "metadata."); ok && metadataKeyPattern.MatchString(key) {
+			parts = append(parts, metadataSortExpr(key)+" "+dir(k)+" NULLS LAST")
+			continue
+		}
+		if !sortColumns[k.Column] {
+			return "", fmt.Errorf("unsortable column %q", k.Column)
+		}
+		parts = append(parts, k.Column+" "+dir(k))
+		hasID = hasID || k.Column == "id"
+	}
+	if !hasID {
+		parts = append(parts, "id "+dir(keys[0]))
+	}
+	return "ORDER BY " + strings.Join(parts, ", ") + " ", nil
+}
+
+// whereClause joins conditions into "WHERE ... " (or "" when there are none).
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conds, " AND ") + " "
+}
+
+func (r *pgUserRepository) Get(ctx context.Context, id int, fields ...string) (User, error) {
+	var u User
+	cols := columnsFor(fields)
+	err := r.db.QueryRow(ctx, "SELECT "+strings.Join(cols, ", ")+" FROM users WHERE id=$1 AND "+notDeleted, id).Scan(u.scanFieldsFor(cols)...)
+	return u, repoError(err)
+}
+
+func (r *pgUserRepository) GetByEmail(ctx context.Context, key string) (User, error) {
+	var u User
+	err := r.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE email_key=$1 AND "+notDeleted, key).Scan(u.scanFields()...)
+	return u, repoError(err)
+}
+
+// insertUserSQL is the INSERT behind Create, PreviewCreate and CreateMany;
+// its arguments come from UserInput.insertArgs.
+func insertUserSQL(skipDuplicates bool) string {
+	q := `INSERT INTO users (name, email, email_key, metadata)
+		 VALUES ($1, $2, $3, COALESCE($4, '{}'::jsonb))`
+	if skipDuplicates {
+		q += " ON CONFLICT (email_key) DO NOTHING"
+	}
+	return q + " RETURNING " + userColumns
+}
+
+func (in UserInput) insertArgs() []any {
+	return []any{in.Name, in.Email, in.EmailKey, in.Metadata}
+}
+
+func (r *pgUserRepository) Create(ctx context.Context, in UserInput) (User, error) {
+	return r.insert(ctx, in, false)
+}
+
+func (r *pgUserRepository) PreviewCreate(ctx context.Context, in UserInput) (User, error) {
+	return r.insert(ctx, in, true)
+}
+
+// errPreview aborts PreviewCreate's transaction after the insert.
+var errPreview = errors.New("preview")
+
+// insert creates one user and queues its webhook. A preview runs the same
+// insert but rolls it back, so defaults, triggers and constraints all apply
+// without anything being kept or announced.
+func (r *pgUserRepository) insert(ctx context.Context, in UserInput, preview bool) (User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, insertUserSQL(false), in.insertArgs()...).Scan(u.scanFields()...); err != nil {
+			return err
+		}
+		if preview {
+			return errPreview
+		}
+		return enqueueWebhook(ctx, tx, r.webhookURLs, eventUserCreated, u)
+	})
+	if preview && errors.Is(err, errPreview) {
+		return u, nil
+	}
+	return u, r.emailError(ctx, in.EmailKey, err)
+}
+
+func (r *pgUserRepository) CreateMany(ctx context.Context, ins []UserInput, skipDuplicates bool) ([]*User, error) {
+	if len(ins) == 0 {
+		return nil, nil
+	}
+	insert := insertUserSQL(skipDuplicates)
+	out := make([]*User, len(ins))
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		// One round trip for all rows; results come back in input order,
+		// so the first error belongs to the row that caused it
+		batch := &pgx.Batch{}
+		for _, in := range ins {
+			batch.Queue(insert, in.insertArgs()...)
+		}
+		results := tx.SendBatch(ctx, batch)
+		for i := range ins {
+			var u User
+			err := results.QueryRow().Scan(u.scanFields()...)
+			if skipDuplicates && errors.Is(err, pgx.ErrNoRows) {
+				continue // ON CONFLICT skipped it
+			}
+			if err != nil {
+				results.Close()
+				return &BatchError{Index: i, Err: r.emailError(ctx, ins[i].EmailKey, err)}
+			}
+			out[i] = &u
+		}
+		if err := results.Close(); err != nil {
+			return err
+		}
+
+		for _, u := range out {
+			if u == nil {
+				continue
+			}
+			if err := enqueueWebhook(ctx, tx, r.webhookURLs, eventUserCreated, *u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Update replaces name and email; metadata is kept when not provided.
+func (r *pgUserRepository) Update(ctx context.Context, id int, in UserInput) (User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx,
+			`UPDATE users
+			 SET name=$2, email=$3, email_key=$4, metadata=COALESCE($5, metadata), updated_at=now()
+			 WHERE id=$1 AND `+notDeleted+` AND ($6::timestamptz IS NULL OR updated_at=$6)
+			 RETURNING `+userColumns,
+			id, in.Name, in.Email, in.EmailKey, in.Metadata, in.IfUpdatedAt,
+		).Scan(u.scanFields()...)
+		if err != nil {
+			return versionError(ctx, tx, id, in.IfUpdatedAt, err)
+		}
+		return enqueueWebhook(ctx, tx, r.webhookURLs, eventUserUpdated, u)
+	})
+	return u, r.emailError(ctx, in.EmailKey, err)
+}
+
+// Patch updates only the non-nil fields of p. p must set at least one field.
+func (r *pgUserRepository) Patch(ctx context.Context, id int, p UserPatch) (User, error) {
+	// Build SET clause from the supplied fields only
+	args := []any{id}
+	var sets []string
+	if p.Name != nil {
+		args = append(args, *p.Name)
+		sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+	}
+	if p.Email != nil {
+		args = append(args, *p.Email, *p.EmailKey)
+		sets = append(sets, fmt.Sprintf("email=$%d, email_key=$%d", len(args)-1, len(args)))
+	}
+	if p.Metadata != nil {
+		args = append(args, p.Metadata)
+		sets = append(sets, fmt.Sprintf("metadata=$%d", len(args)))
+	}
+	sets = append(sets, "updated_at=now()")
+	where := "WHERE id=$1 AND " + notDeleted
+	if p.IfUpdatedAt != nil {
+		args = append(args, *p.IfUpdatedAt)
+		where += fmt.Sprintf(" AND updated_at=$%d", len(args))
+	}
+
+	var u User
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx,
+			"UPDATE users SET "+strings.Join(sets, ", ")+" "+where+" RETURNING "+userColumns,
+			args...,
+		).Scan(u.scanFields()...)
+		if err != nil {
+			return versionError(ctx, tx, id, p.IfUpdatedAt, err)
+		}
+		return enqueueWebhook(ctx, tx, r.webhookURLs, eventUserUpdated, u)
+	})
+	if p.EmailKey != nil {
+		return u, r.emailError(ctx, *p.EmailKey, err)
+	}
+	return u, repoError(err)
+}
+
+func (r *pgUserRepository) Delete(ctx context.Context, id int) error {
+	// The row stays (and keeps its email) until restored; the webhook
+	// payload carries deleted_at
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var u User
+		err := tx.QueryRow(ctx,
+			"UPDATE users SET deleted_at=now() WHERE id=$1 AND "+notDeleted+" RETURNING "+userColumns,
+			id,
+		).Scan(u.scanFields()...)
+		if err != nil {
+			return err
+		}
+		return enqueueWebhook(ctx, tx, r.webhookURLs, eventUserDeleted, u)
+	})
+	return repoError(err)
+}
+
+func (r *pgUserRepository) Restore(ctx context.Context, id int) (User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx,
+			"UPDATE users SET deleted_at=NULL WHERE id=$1 AND deleted_at IS NOT NULL RETURNING "+userColumns,
+			id,
+		).Scan(u.scanFields()...)
+		if err != nil {
+			return err
+		}
+		return enqueueWebhook(ctx, tx, r.webhookURLs, eventUserRestored, u)
+	})
+	return u, repoError(err)
+}
+
+func (r *pgUserRepository) Touch(ctx context.Context, ids []int) (int, error) {
+	// One statement for all ids; each touched user still gets its own webhook
+	n := 0
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "UPDATE users SET updated_at=now() WHERE id = ANY($1) AND "+notDeleted+" RETURNING "+userColumns, ids)
+		if err != nil {
+			return err
+		}
+		touched, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (User, error) {
+			var u User
+			err := row.Scan(u.scanFields()...)
+			return u, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, u := range touched {
+			if err := enqueueWebhook(ctx, tx, r.webhookURLs, eventUserUpdated, u); err != nil {
+				return err
+			}
+		}
+		n = len(touched)
+		return nil
+	})
+	return n, err
+}
+
+func (r *pgUserRepository) IncrementCounters(ctx context.Context, id int, deltas map[string]int64) (User, error) {
+	// Each counter moves from its current value inside the UPDATE, so
+	// concurrent calls never lose each other's increments. The WHERE clause
+	// refuses anything that would go below zero.
+	args := []any{id}
+	var sets []string
+	conds := []string{"id=$1", notDeleted}
+	for _, name := range slices.Sorted(maps.Keys(deltas)) {
+		if !slices.Contains(userCounters, name) {
+			return User{}, fmt.Errorf("unknown counter %q", name)
+		}
+		args = append(args, deltas[name])
+		sets = append(sets, fmt.Sprintf("%s=%s+$%d", name, name, len(args)))
+		conds = append(conds, fmt.Sprintf("%s+$%d >= 0", name, len(args)))
+	}
+	if r.touchUpdatedAt {
+		sets = append(sets, "updated_at=now()")
+	}
+
+	var u User
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx,
+			"UPDATE users SET "+strings.Join(sets, ", ")+" WHERE "+strings.Join(conds, " AND ")+" RETURNING "+userColumns,
+			args...,
+		).Scan(u.scanFields()...)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// A live user means the zero floor is what stopped the update
+			var exists bool
+			if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND "+notDeleted+")", id).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return ErrCounterNegative
+			}
+			return err
+		}
+		// Counters alone aren't an update of the user (see counters.go)
+		if err != nil || !r.touchUpdatedAt {
+			return err
+		}
+		return enqueueWebhook(ctx, tx, r.webhookURLs, eventUserUpdated, u)
+	})
+	if isNumericOutOfRange(err) {
+		return User{}, ErrCounterOverflow
+	}
+	return u, repoError(err)
+}
+
+// versionError tells a failed conditional update apart from a missing user:
+// if the user is still there, the version check is what rejected the write.
+// The check itself is part of the UPDATE, so this only classifies the error.
+func versionError(ctx context.Context, tx pgx.Tx, id int, version *time.Time, err error) error {
+	if version == nil || !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	var exists bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND "+notDeleted+")", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionMismatch
+	}
+	return pgx.ErrNoRows
+}
+
+// emailError is repoError for writes that set email_key: when the email is
+// taken by a soft-deleted user it returns a DeletedEmailError naming them.
+func (r *pgUserRepository) emailError(ctx context.Context, key string, err error) error {
+	if !isUniqueViolation(err) {
+		return repoError(err)
+	}
+	var id int
+	if r.db.QueryRow(ctx, "SELECT id FROM users WHERE email_key=$1 AND deleted_at IS NOT NULL", key).Scan(&id) == nil {
+		return &DeletedEmailError{UserID: id}
+	}
+	return ErrEmailTaken
+}
+
+// repoError translates driver errors into the repository's typed errors.
+func repoError(err error) error {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrUserNotFound
+	case isUniqueViolation(err):
+		return ErrEmailTaken
+	default:
+		return err
+	}
+}
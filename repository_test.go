@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Whatever reaches orderBy, the SQL it emits holds only allowlisted
+// columns, checked metadata keys and the words ASC/DESC.
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []SortKey
+		want string // "" when orderBy must refuse
+	}{
+		{"default", nil, "ORDER BY id ASC "},
+		{"column", []SortKey{{Column: "name", Order: "desc"}}, "ORDER BY name DESC, id DESC "},
+		{"several", []SortKey{{Column: "email", Order: "asc"}, {Column: "id", Order: "desc"}}, "ORDER BY email ASC, id DESC "},
+		{"metadata", []SortKey{{Column: "metadata.team", Order: "asc"}}, "ORDER BY metadata->>'team' ASC NULLS LAST, id ASC "},
+		{"crafted order", []SortKey{{Column: "name", Order: "desc; DROP TABLE users; --"}}, "ORDER BY name ASC, id ASC "},
+		{"crafted column", []SortKey{{Column: "id; DROP TABLE users", Order: "asc"}}, ""},
+		{"subquery", []SortKey{{Column: "(SELECT 1)", Order: "asc"}}, ""},
+		{"unknown column", []SortKey{{Column: "password_hash", Order: "asc"}}, ""},
+		{"crafted metadata key", []SortKey{{Column: "metadata.x'; DROP TABLE users; --", Order: "asc"}}, ""},
+		{"metadata path", []SortKey{{Column: "metadata.a.b", Order: "asc"}}, ""},
+	}
+	for _, tc := range tests {
+		got, err := orderBy(tc.keys)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: orderBy = %q, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: orderBy = %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+// sqlRecorder is a fakeRepo that also builds the SQL the Postgres
+// repository would run for every listing, and records its text.
+type sqlRecorder struct {
+	*fakeRepo
+	t     *testing.T
+	texts []string
+}
+
+func (r *sqlRecorder) record(f UserFilter) {
+	q, err := buildListQuery(f)
+	if err != nil {
+		r.t.Errorf("buildListQuery(%+v): %v", f, err)
+		return
+	}
+	// Every value is bound: one argument per placeholder, none left over
+	for i := 1; i <= len(q.args); i++ {
+		if !strings.Contains(q.sql, "$"+strconv.Itoa(i)) {
+			r.t.Errorf("argument $%d is not used in %s", i, q.sql)
+		}
+	}
+	if strings.Contains(q.sql, "$"+strconv.Itoa(len(q.args)+1)) {
+		r.t.Errorf("%s has more placeholders than its %d args", q.sql, len(q.args))
+	}
+	r.texts = append(r.texts, q.sql)
+}
+
+func (r *sqlRecorder) List(ctx context.Context, f UserFilter) (UserPage, error) {
+	r.record(f)
+	return r.fakeRepo.List(ctx, f)
+}
+
+func (r *sqlRecorder) Each(ctx context.Context, f UserFilter, fn func(u User, total *int) error) (UserPage, error) {
+	r.record(f)
+	return r.fakeRepo.Each(ctx, f, fn)
+}
+
+// GET /users over the full matrix of its parameters produces one SQL text
+// per combination of which parameters are used (and the sort), however the
+// values vary, so pgx's statement cache sees a bounded set of statements.
+func TestListSQLTexts(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MetadataSortKeys = map[string]bool{"team": true}
+	rec := &sqlRecorder{fakeRepo: seedUsers(), t: t}
+	r := testRouter(t, rec, cfg)
+
+	cursorValues := map[string]any{"id": 1, "name": "Ada", "created_at": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	shapes := map[string]map[string]bool{} // shape -> the SQL texts it produced
+	for _, q := range []string{"", "ada", "o_l%"} {
+		for _, team := range []string{"", "math", "navy"} {
+			for _, after := range []string{"", "2020-01-01T00:00:00Z", "2024-06-01T00:00:00Z"} {
+				for _, sort := range []string{"id", "-name", "created_at", "metadata.team"} {
+					for _, order := range []string{"asc", "desc"} {
+						for _, page := range []string{"limit=1", "limit=50&offset=3", "cursor"} {
+							for _, fields := range []string{"", "name"} {
+								params := url.Values{"q": {q}, "metadata.team": {team}, "created_after": {after},
+									"sort": {sort}, "order": {order}, "fields": {fields}}
+								if page == "cursor" {
+									col, desc := strings.CutPrefix(sort, "-")
+									v, ok := cursorValues[col]
+									if !ok {
+										continue // metadata sorts have no cursors
+									}
+									dir := map[bool]string{true: "desc", false: order}[desc]
+									params.Set("cursor", encodeCursor(Cursor{Sort: col, Order: dir, Value: v, ID: 1}))
+								} else {
+									p, _ := url.ParseQuery(page)
+									maps.Copy(params, p)
+								}
+								for k, v := range params {
+									if v[0] == "" {
+										delete(params, k)
+									}
+								}
+
+								before := len(rec.texts)
+								if w := serve(r, "GET", "/users?"+params.Encode(), ""); w.Code != 200 {
+									t.Fatalf("GET /users?%s = %d\n%s", params.Encode(), w.Code, w.Body)
+								}
+								shape := fmt.Sprint(q != "", team != "", after != "", sort, order, page == "cursor", fields)
+								if shapes[shape] == nil {
+									shapes[shape] = map[string]bool{}
+								}
+								for _, text := range rec.texts[before:] {
+									shapes[shape][text] = true
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	distinct := map[string]bool{}
+	for shape, texts := range shapes {
+		if len(texts) != 1 {
+			t.Errorf("shape %s produced %d SQL texts, want 1:\n%s", shape, len(texts), strings.Join(slices.Collect(maps.Keys(texts)), "\n"))
+		}
+		maps.Copy(distinct, texts)
+	}
+	if len(distinct) > len(shapes) {
+		t.Errorf("%d distinct SQL texts for %d parameter shapes", len(distinct), len(shapes))
+	}
+	t.Logf("%d listings, %d shapes, %d distinct SQL texts", len(rec.texts), len(shapes), len(distinct))
+}
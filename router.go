@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"maps"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// RouterDeps are the dependencies NewRouter wires into handlers.
+type RouterDeps struct {
+	Users UserRepository // storage for /users
+	DB    *pgxpool.Pool  // used directly by health checks and admin webhook endpoints
+
+	Config Config // settings for middleware and handlers (see config.go)
+
+	// HealthChecks are extra readiness checks by name; "postgres" is added
+	// for DB automatically.
+	HealthChecks map[string]Checker
+}
+
+// NewRouter builds the Gin engine with every route and middleware registered.
+func NewRouter(deps RouterDeps) *gin.Engine {
+	// Request IDs and JSON request logs (see logging.go) replace Gin's
+	// plaintext logger; Recovery turns panics into 500s. withTimeout (see
+	// server.go) puts a deadline on everything a handler does.
+	r := gin.New()
+	r.Use(requestID, logRequests, gin.Recovery(), countInFlight, recordMetrics,
+		withTimeout(deps.Config.SafeRequestTimeout, deps.Config.UnsafeRequestTimeout, deps.Config.ExportTimeout))
+
+	if deps.Config.ForceHTTPS {
+		r.Use(forceHTTPS(deps.Config.TrustedProxies, deps.Config.HSTSMaxAge)) // see https.go
+	}
+	if cors := newCORS(deps.Config); cors != nil {
+		r.Use(cors) // see cors.go; answers preflights before rate limits and auth
+	}
+	if limit := newRateLimiter(deps.Config.RateLimit, deps.Config.RateLimitBurst); limit != nil {
+		r.Use(limit) // see ratelimit.go
+	}
+	if deps.Config.AuthAllRoutes {
+		r.Use(requireAPIKeyExceptProbes(deps.Config.APIKeys, deps.Config.AdminAPIKey)) // see auth.go
+	}
+	if deps.Config.Debug && !deps.Config.Production {
+		r.Use(debugHooks(deps.Config.DebugMaxDelay)) // see debug.go
+	}
+
+	// c.ClientIP() (logs, rate limits) trusts X-Forwarded-For only from these
+	if err := r.SetTrustedProxies(deps.Config.TrustedProxies); err != nil {
+		log.Fatalf("❌ Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Make c.Done()/c.Err() follow the request context, so queries given
+	// the gin context are cancelled when the client or server gives up.
+	r.ContextWithFallback = true
+
+	// Path params are decoded by pathValue (see params.go), not by the router,
+	// so "%2F" can't turn into a path separator behind our back.
+	r.UseRawPath = true
+	r.UnescapePathValues = false
+
+	// Health checks (see health.go): /healthz (and /health/live) for
+	// liveness probes, /readyz (and /health/ready, /health) for readiness
+	r.GET("/healthz", liveness)
+	r.GET("/health/live", liveness)
+	checks := map[string]Checker{}
+	maps.Copy(checks, deps.HealthChecks)
+	if deps.DB != nil {
+		checks["postgres"] = deps.DB.Ping
+	}
+	ready := readiness(checks)
+	r.GET("/readyz", ready)
+	r.GET("/health/ready", ready)
+	r.GET("/health", ready)
+
+	// Prometheus scrape endpoint (see metrics.go)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// API description and its Swagger UI (see openapi.go)
+	spec := openAPISpec(deps.Config)
+	r.GET("/openapi.json", serveOpenAPI(spec))
+	r.GET("/users/schema", serveUserSchema) // form schema, see formschema.go
+	r.GET("/docs", serveDocs)
+
+	// Reads are open (unless AUTH_ALL_ROUTES); writes need an API key when
+	// API_KEYS is set (see auth.go). Reads are cacheable, writes never are
+	// (see cache.go); the list's format depends on Accept and Prefer.
+	users := NewUserHandler(deps.Users, deps.Config)
+	cacheUsers := cacheFor(deps.Config.CacheMaxAgeUsers)
+	r.GET("/users", cacheFor(deps.Config.CacheMaxAgeUsers, "Accept", "Prefer"), users.List)
+	r.GET("/users.csv", cacheUsers, users.Export("csv")) // see export.go
+	r.GET("/users/export", cacheUsers, users.Export("ndjson"))
+	cacheUser := cacheFor(deps.Config.CacheMaxAgeUser)
+	r.GET("/users/:id", cacheUser, users.Get)
+	r.GET("/users/by-email/:email", cacheUser, users.GetByEmail)
+
+	writes := r.Group("", noStore, requireAPIKey(deps.Config.APIKeys, deps.Config.AdminAPIKey))
+	writes.POST("/users", idempotent(deps.DB, deps.Config.IdempotencyTTL), users.Create) // see idempotency.go
+	writes.POST("/users/batch", users.CreateBatch)
+	writes.POST("/users/touch", users.Touch)
+	writes.PUT("/users/:id", users.Update)
+	writes.PATCH("/users/:id", users.Patch)
+	writes.DELETE("/users/:id", users.Delete)
+	writes.POST("/users/:id/counters", users.IncrementCounters) // see counters.go
+
+	// Operator endpoints, all behind the admin API key (see auth.go)
+	admin := r.Group("/admin", noStore, requireAdmin(deps.Config.AdminAPIKey))
+	if deps.DB != nil {
+		registerWebhookAdminRoutes(admin, deps.DB)
+	}
+	registerDebugAdminRoutes(admin)
+	admin.POST("/users/:id/restore", users.Restore)
+
+	checkSpecRoutes(spec, r.Routes())
+	return r
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Search cost guard for ?q=. A substring ILIKE can't use a plain index, so
+// short or very common terms scan most of the table. Prefix matching can: it
+// uses the lower(name) and lower(email) pattern indexes (migration 0008).
+//
+//	SEARCH_MIN_LENGTH    shortest accepted term, in characters (default 2)
+//	SEARCH_MAX_ESTIMATE  planner row estimate above which the search falls
+//	                     back to prefix matching (default 0 = never)
+
+// guardSearch applies the search cost guard configured in cfg to f. It
+// returns warnings for the response when the search was downgraded. On
+// failure it writes the error response itself and returns false.
+func guardSearch(c *gin.Context, repo UserRepository, cfg Config, f *UserFilter) ([]string, bool) {
+	if f.Query == "" {
+		return nil, true
+	}
+	if utf8.RuneCountInString(f.Query) < cfg.SearchMinLength {
+		respondError(c, http.StatusBadRequest, gin.H{"error": fmt.Sprintf(
+			"q must be at least %d characters; narrow the listing with other filters instead", cfg.SearchMinLength)})
+		return nil, false
+	}
+	if cfg.SearchMaxEstimate <= 0 {
+		return nil, true
+	}
+
+	// EXPLAIN only plans the query, so asking is cheap
+	n, err := repo.EstimateRows(c, *f)
+	if err != nil {
+		respondRepoError(c, err)
+		return nil, false
+	}
+	if n <= cfg.SearchMaxEstimate {
+		return nil, true
+	}
+	f.Prefix = true
+	return []string{"q matches too many users; only names and emails starting with it are returned"}, true
+}
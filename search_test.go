@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// skewedUsers returns a dataset where "an" is everywhere: 200 Annas and
+// Susans at bigcorp, and a handful of users matching nothing common.
+func skewedUsers() []User {
+	var users []User
+	for i := range 100 {
+		users = append(users,
+			User{Name: fmt.Sprintf("Anna Smith %d", i), Email: fmt.Sprintf("anna%d@bigcorp.test", i)},
+			User{Name: fmt.Sprintf("Susan Jones %d", i), Email: fmt.Sprintf("susan%d@bigcorp.test", i)})
+	}
+	for _, name := range []string{"Zed", "Yolo", "Xi 100%"} {
+		users = append(users, User{Name: name, Email: strings.ToLower(strings.Fields(name)[0]) + "@rare.test"})
+	}
+	return users
+}
+
+func TestSearchGuard(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SearchMinLength = 2
+	cfg.SearchMaxEstimate = 50
+
+	tests := []struct {
+		name   string
+		q      string
+		status int
+		prefix bool
+		total  float64
+	}{
+		// "an" is in all 200: only names and emails starting with it remain
+		{"common term", "an", 200, true, 100},
+		{"rare term", "zed", 200, false, 1},
+		{"under the threshold", "susan1", 200, false, 11},
+		{"too short", "a", 400, false, 0},
+		{"short in bytes, not characters", "éé", 200, false, 0},
+		{"wildcards match literally", "100%", 200, false, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRouter(t, newFakeRepo(skewedUsers()...), cfg)
+			w := serve(r, "GET", "/users?q="+strings.ReplaceAll(tc.q, "%", "%25"), "")
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d\n%s", w.Code, tc.status, w.Body)
+			}
+			body := jsonObject(t, w)
+			if tc.status != 200 {
+				if msg, _ := body["error"].(string); !strings.Contains(msg, "at least 2 characters") {
+					t.Errorf("error = %q, want guidance on the minimum length", msg)
+				}
+				return
+			}
+			if got := w.Header().Get("X-Search-Mode") == "prefix"; got != tc.prefix {
+				t.Errorf("downgraded to prefix = %v, want %v", got, tc.prefix)
+			}
+			if _, warned := body["warnings"]; warned != tc.prefix {
+				t.Errorf("warnings = %v, want them only on a downgrade", body["warnings"])
+			}
+			if body["total"] != tc.total {
+				t.Errorf("total = %v, want %v", body["total"], tc.total)
+			}
+		})
+	}
+}
+
+// Streamed listings can't carry warnings, but still say they were downgraded.
+func TestSearchGuardStreamed(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SearchMaxEstimate = 50
+	r := testRouter(t, newFakeRepo(skewedUsers()...), cfg)
+	w := serve(r, "GET", "/users?q=an&limit=100", "", "Prefer", "streaming")
+	if w.Header().Get("X-Search-Mode") != "prefix" {
+		t.Errorf("streamed search was not marked as downgraded")
+	}
+	if n := len(streamedUsers(t, w.Body.Bytes())); n != 100 {
+		t.Errorf("streamed %d users, want the 100 Annas", n)
+	}
+}
+
+func TestSearchGuardEstimateFails(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SearchMaxEstimate = 50
+	repo := newFakeRepo(skewedUsers()...)
+	repo.err = errors.New("explain failed")
+	if w := serve(testRouter(t, repo, cfg), "GET", "/users?q=an", ""); w.Code != 500 {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
+
+// Against Postgres: the planner rates the common term as the bigger search,
+// and both the substring and the prefix query match q literally. Set
+// TEST_DB_URL to a scratch database to run it.
+func TestSearchPostgres(t *testing.T) {
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL not set")
+	}
+	ctx := context.Background()
+	cfg := testConfig(t)
+	cfg.DBURL = dbURL
+	db, err := ConnectDB(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	run := fmt.Sprint(time.Now().UnixNano())
+	repo := NewUserRepository(db, cfg)
+	t.Cleanup(func() { db.Exec(ctx, "DELETE FROM users WHERE metadata->>'search_run' = $1", run) })
+	var ins []UserInput
+	for _, u := range skewedUsers() {
+		email := run + u.Email
+		key, _ := emailKey(email, false)
+		ins = append(ins, UserInput{Name: u.Name, Email: email, EmailKey: key, Metadata: map[string]any{"search_run": run}})
+	}
+	if _, err := repo.CreateMany(ctx, ins, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, "ANALYZE users"); err != nil {
+		t.Fatal(err)
+	}
+
+	common, err := repo.EstimateRows(ctx, UserFilter{Query: "an"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rare, err := repo.EstimateRows(ctx, UserFilter{Query: "yolo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common <= rare {
+		t.Errorf("estimates: %d rows for a common term, %d for a rare one", common, rare)
+	}
+
+	meta := map[string]string{"search_run": run}
+	for _, tc := range []struct {
+		f    UserFilter
+		want int
+	}{
+		{UserFilter{Query: "an", Metadata: meta}, 200},
+		{UserFilter{Query: "an", Prefix: true, Metadata: meta}, 100},
+		{UserFilter{Query: "AN", Prefix: true, Metadata: meta}, 100},
+		{UserFilter{Query: "100%", Metadata: meta}, 1},
+		{UserFilter{Query: "Xi 1_0", Prefix: true, Metadata: meta}, 0},
+	} {
+		page, err := repo.List(ctx, UserFilter{Query: tc.f.Query, Prefix: tc.f.Prefix, Metadata: tc.f.Metadata, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *page.Total != tc.want {
+			t.Errorf("q=%q prefix=%v: %d matches, want %d", tc.f.Query, tc.f.Prefix, *page.Total, tc.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inFlight counts requests currently being served, for the shutdown log line.
+var inFlight atomic.Int64
+
+// countInFlight is middleware that keeps inFlight up to date.
+func countInFlight(c *gin.Context) {
+	inFlight.Add(1)
+	defer inFlight.Add(-1)
+	c.Next()
+}
+
+// withTimeout returns middleware that puts a deadline on the request context:
+// safe for GET, HEAD and OPTIONS, unsafe for everything else, and export
+// for the dedicated export routes (see exportRoutes), which stream for as
+// long as the data takes (0 disables each).
+// DB calls take the request context, so a slow query is cancelled at the
+// deadline and the client gets a 504 (see respondInternalError).
+func withTimeout(safe, unsafe, export time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := unsafe
+		switch {
+		case exportRoutes[c.FullPath()]:
+			timeout = export
+		case c.Request.Method == http.MethodGet, c.Request.Method == http.MethodHead, c.Request.Method == http.MethodOptions:
+			timeout = safe
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// httpServer is the API listener as a lifecycle component.
+type httpServer struct {
+	srv            *http.Server
+	cancelRequests context.CancelFunc
+	errs           chan error // Serve's result, e.g. a listener failure
+}
+
+// newHTTPServer prepares to serve handler on cfg.ListenAddr.
+func newHTTPServer(handler http.Handler, cfg Config) *httpServer {
+	// Request contexts derive from baseCtx so we can cancel them all at once.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	return &httpServer{
+		srv: &http.Server{
+			Addr:         cfg.ListenAddr,
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			BaseContext:  func(net.Listener) context.Context { return baseCtx },
+		},
+		cancelRequests: cancelRequests,
+		errs:           make(chan error, 1),
+	}
+}
+
+// start binds the port (so "address in use" fails startup) and serves in
+// the background.
+func (s *httpServer) start(context.Context) error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("🚀 Listening on %s", s.srv.Addr)
+	go func() { s.errs <- s.srv.Serve(ln) }()
+	return nil
+}
+
+// shutdown drains until ctx is done.
+//
+// It stops accepting connections and waits for in-flight requests to
+// finish. If they don't in time, every request context is cancelled, which
+// aborts their DB queries, and the remaining connections are closed.
+// shutdown returns only after draining, so the DB pool can then be closed.
+func (s *httpServer) shutdown(ctx context.Context) error {
+	defer s.cancelRequests()
+	if deadline, ok := ctx.Deadline(); ok {
+		log.Printf("🛑 Shutting down, waiting for %d in-flight requests (timeout %s)", inFlight.Load(), time.Until(deadline).Round(time.Second))
+	}
+
+	err := s.srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("⚠️ Drain timed out with %d requests still running; cancelling them", inFlight.Load())
+		s.cancelRequests()
+		err = s.srv.Close()
+	}
+	return err
+}
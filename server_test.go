@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// A query still running at the request deadline is cancelled, and the
+// client gets a 504 rather than a 500 naming the context error.
+func TestSlowQueryTimesOut(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SafeRequestTimeout = 20 * time.Millisecond
+	cfg.UnsafeRequestTimeout = 20 * time.Millisecond
+	repo := seedUsers()
+	repo.delay = 5 * time.Second
+	r := testRouter(t, repo, cfg)
+
+	for _, req := range []struct{ method, target, body string }{
+		{"GET", "/users", ""},
+		{"GET", "/users/1", ""},
+		{"PUT", "/users/1", `{"name":"Ada","email":"ada@example.com"}`},
+	} {
+		start := time.Now()
+		w := serve(r, req.method, req.target, req.body)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s %s took %v, want it cut off at the deadline", req.method, req.target, elapsed)
+		}
+		if w.Code != 504 {
+			t.Fatalf("%s %s = %d, want 504\n%s", req.method, req.target, w.Code, w.Body)
+		}
+		if body := jsonObject(t, w); body["error"] != "request timed out" {
+			t.Errorf("body = %v, want the clean timeout message", body)
+		}
+	}
+}
+
+// Postgres cancelling a statement itself (statement_timeout) is a 504 too.
+func TestStatementTimeout(t *testing.T) {
+	repo := seedUsers()
+	repo.err = &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"}
+	w := serve(testRouter(t, repo, testConfig(t)), "GET", "/users/1", "")
+	if w.Code != 504 {
+		t.Errorf("status = %d, want 504\n%s", w.Code, w.Body)
+	}
+}
+
+func TestWithTimeoutByRoute(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SafeRequestTimeout = 50 * time.Millisecond
+	cfg.UnsafeRequestTimeout = 5 * time.Second
+	cfg.ExportTimeout = 0
+	repo := seedUsers()
+	repo.delay = 100 * time.Millisecond
+	r := testRouter(t, repo, cfg)
+
+	// Past the read deadline, within the write one
+	if w := serve(r, "GET", "/users/1", ""); w.Code != 504 {
+		t.Errorf("GET = %d, want 504", w.Code)
+	}
+	if w := serve(r, "PATCH", "/users/1", `{"name":"Ada"}`); w.Code != 200 {
+		t.Errorf("PATCH = %d, want 200\n%s", w.Code, w.Body)
+	}
+	// Exports have their own (here: no) deadline
+	if w := serve(r, "GET", "/users/export", ""); w.Code != 200 {
+		t.Errorf("export = %d, want 200\n%s", w.Code, w.Body)
+	}
+}
+
+// startTestServer serves h through newHTTPServer on a free local port and
+// returns its base URL.
+func startTestServer(t *testing.T, h http.Handler) (*httpServer, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := testConfig(t)
+	cfg.ListenAddr = addr
+	s := newHTTPServer(h, cfg)
+	if err := s.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, "http://" + addr
+}
+
+// Requests already running when shutdown begins finish normally; new
+// connections are refused.
+func TestShutdownDrains(t *testing.T) {
+	started := make(chan struct{})
+	s, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	}))
+
+	type result struct {
+		body string
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		res <- result{string(b), err}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown = %v, want a clean drain", err)
+	}
+	if r := <-res; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request got %q, %v; want it to complete", r.body, r.err)
+	}
+	if _, err := http.Get(url + "/slow"); err == nil {
+		t.Error("server still accepting requests after shutdown")
+	}
+}
+
+// Past the drain timeout, the requests still running have their contexts
+// cancelled (aborting their queries) and shutdown returns.
+func TestShutdownCancelsStragglers(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	s, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+	}))
+	go http.Get(url + "/stuck")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	s.shutdown(ctx)
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %v past a 50ms drain timeout", elapsed)
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("request context ended with %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("straggling request's context was never cancelled")
+	}
+}
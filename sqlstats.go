@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sqlWindow is how far back recentSQL remembers, in minutes.
+const sqlWindow = 60
+
+// recentSQL counts the SQL texts sent by this process, per minute, for the
+// last sqlWindow minutes. Each distinct text costs a statement cache slot
+// on every connection, so unintended variants show up here first.
+var recentSQL = &sqlCounter{}
+
+// sqlCounter is a ring of per-minute counts keyed by SQL text.
+type sqlCounter struct {
+	mu      sync.Mutex
+	minutes [sqlWindow]map[string]int
+	stamps  [sqlWindow]int64 // which minute (Unix time / 60) each slot holds
+}
+
+// record counts one execution of sql in the current minute.
+func (s *sqlCounter) record(sql string) {
+	now := time.Now().Unix() / 60
+	slot := now % sqlWindow
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stamps[slot] != now || s.minutes[slot] == nil {
+		s.minutes[slot] = map[string]int{}
+		s.stamps[slot] = now
+	}
+	s.minutes[slot][sql]++
+}
+
+// sqlCount is one row of the debug listing.
+type sqlCount struct {
+	SQL   string `json:"sql"`
+	Count int    `json:"count"`
+}
+
+// since returns the texts seen in the last n minutes, most frequent first.
+func (s *sqlCounter) since(n int) []sqlCount {
+	now := time.Now().Unix() / 60
+	totals := map[string]int{}
+
+	s.mu.Lock()
+	for i, m := range s.minutes {
+		if s.stamps[i] > now-int64(n) {
+			for sql, c := range m {
+				totals[sql] += c
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	out := make([]sqlCount, 0, len(totals))
+	for sql, c := range totals {
+		out = append(out, sqlCount{SQL: sql, Count: c})
+	}
+	slices.SortFunc(out, func(a, b sqlCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.SQL, b.SQL))
+	})
+	return out
+}
+
+// registerDebugAdminRoutes mounts diagnostics on an admin group.
+//
+//	GET /debug/sql  distinct SQL texts of the last ?minutes= (default 10, max 60) with counts
+func registerDebugAdminRoutes(g *gin.RouterGroup) {
+	g.GET("/debug/sql", func(c *gin.Context) {
+		minutes := 10
+		if n, err := strconv.Atoi(c.Query("minutes")); err == nil && n > 0 && n <= sqlWindow {
+			minutes = n
+		}
+		texts := recentSQL.since(minutes)
+		c.JSON(http.StatusOK, struct {
+			ListResponse[sqlCount]
+			Minutes  int `json:"minutes"`
+			Distinct int `json:"distinct"`
+		}{newList(texts), minutes, len(texts)})
+	})
+}
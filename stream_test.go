@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// manyUsers returns a fakeRepo holding n users.
+func manyUsers(n int) *fakeRepo {
+	users := make([]User, n)
+	for i := range users {
+		users[i] = User{Name: fmt.Sprintf("User %d", i+1), Email: fmt.Sprintf("user%d@example.com", i+1)}
+	}
+	return newFakeRepo(users...)
+}
+
+// streamedUsers decodes a streamed listing, which must be a JSON array.
+func streamedUsers(t *testing.T, body []byte) []User {
+	t.Helper()
+	var users []User
+	if err := json.Unmarshal(body, &users); err != nil {
+		t.Fatalf("streamed body is not a JSON array: %v\n%s", err, body)
+	}
+	return users
+}
+
+func TestStreamList(t *testing.T) {
+	r := testRouter(t, manyUsers(5), testConfig(t))
+	w := serve(r, "GET", "/users?limit=2&offset=2", "", "Prefer", "streaming")
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200\n%s", w.Code, w.Body)
+	}
+	if users := streamedUsers(t, w.Body.Bytes()); len(users) != 2 || users[0].ID != 3 {
+		t.Errorf("streamed %+v, want users 3 and 4", users)
+	}
+	for name, want := range map[string]string{
+		"Preference-Applied": "streaming",
+		"X-Total-Count":      "5",
+		"Link":               `</users?limit=2&offset=4>; rel="next", </users?limit=2&offset=0>; rel="prev"`,
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if trailer := w.Result().Trailer.Get("X-Stream-Error"); trailer != "" {
+		t.Errorf("X-Stream-Error = %q on a complete stream", trailer)
+	}
+}
+
+// Pages above STREAM_THRESHOLD stream without being asked to.
+func TestStreamThreshold(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.StreamThreshold = 2
+	r := testRouter(t, manyUsers(5), cfg)
+
+	if w := serve(r, "GET", "/users?limit=3", ""); w.Header().Get("Preference-Applied") != "streaming" {
+		t.Errorf("limit above the threshold was not streamed: %s", w.Body)
+	}
+	if w := serve(r, "GET", "/users?limit=2", ""); w.Header().Get("Preference-Applied") != "" {
+		t.Errorf("limit at the threshold was streamed: %s", w.Body)
+	}
+}
+
+func TestStreamEmpty(t *testing.T) {
+	r := testRouter(t, seedUsers(), testConfig(t))
+	w := serve(r, "GET", "/users?q=nomatch", "", "Prefer", "streaming")
+	if w.Code != 200 || w.Body.String() != "[]" {
+		t.Errorf("empty stream = %d %s, want 200 []", w.Code, w.Body)
+	}
+	if got := w.Header().Get("X-Total-Count"); got != "0" {
+		t.Errorf("X-Total-Count = %q, want 0", got)
+	}
+}
+
+// A query failing after rows went out still ends the array, and says so in
+// the trailer; one failing before any row is an ordinary error response.
+func TestStreamError(t *testing.T) {
+	repo := manyUsers(60)
+	repo.err = errors.New("connection reset")
+	repo.failAfter = 40 // past the first flush
+	r := testRouter(t, repo, testConfig(t))
+
+	w := serve(r, "GET", "/users?limit=50", "", "Prefer", "streaming")
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200 (sent before the failure)", w.Code)
+	}
+	if users := streamedUsers(t, w.Body.Bytes()); len(users) != 40 {
+		t.Errorf("streamed %d users, want the 40 before the failure", len(users))
+	}
+	if got := w.Result().Trailer.Get("X-Stream-Error"); got != "stream aborted" {
+		t.Errorf("X-Stream-Error = %q, want %q", got, "stream aborted")
+	}
+
+	repo.failAfter = 0
+	w = serve(r, "GET", "/users?limit=50", "", "Prefer", "streaming")
+	if w.Code != 500 {
+		t.Fatalf("status = %d, want 500\n%s", w.Code, w.Body)
+	}
+	if body := jsonObject(t, w); body["error"] != "internal server error" {
+		t.Errorf("body = %v", body)
+	}
+}
+
+// Compare with -benchmem: the buffered listing holds the whole page and
+// its envelope in memory before writing, the streamed one a row at a time.
+func BenchmarkListBuffered(b *testing.B) { benchmarkList(b, "") }
+func BenchmarkListStreamed(b *testing.B) { benchmarkList(b, "streaming") }
+
+func benchmarkList(b *testing.B, prefer string) {
+	repo := manyUsers(100)
+	for i := range repo.users {
+		repo.users[i].Metadata = map[string]any{"team": "math", "bio": fmt.Sprintf("%0200d", i)}
+	}
+	r := testRouter(b, repo, testConfig(b))
+	b.ReportAllocs()
+	for b.Loop() {
+		if w := serve(r, "GET", "/users?limit=100", "", "Prefer", prefer); w.Code != 200 {
+			b.Fatalf("status = %d", w.Code)
+		}
+	}
+}
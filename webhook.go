@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Webhook event types sent to subscribers.
+const (
+	eventUserCreated  = "user.created"
+	eventUserUpdated  = "user.updated"
+	eventUserDeleted  = "user.deleted"
+	eventUserRestored = "user.restored"
+)
+
+// Outbound webhook settings.
+//
+//	WEBHOOK_URLS          comma-separated subscriber endpoints (empty disables webhooks)
+//	WEBHOOK_SECRET        HMAC-SHA256 key used to sign every delivery
+//	WEBHOOK_MAX_ATTEMPTS  attempts before a delivery is dead-lettered (default 8)
+
+// webhookTimeout is how long a subscriber gets to answer one delivery.
+const webhookTimeout = 10 * time.Second
+
+// webhookClient is used for all deliveries.
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookBatchSize is how many deliveries a worker claims at a time. They
+// are sent one after another, so a batch can take webhookBatchSize times
+// webhookTimeout; the lease outlasts that with a minute to spare, or
+// another worker could reclaim rows still being sent and send them twice.
+const (
+	webhookBatchSize = 10
+	webhookLease     = webhookBatchSize*webhookTimeout + time.Minute
+)
+
+// execer is satisfied by both *pgxpool.Pool and pgx.Tx.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// webhookPayload is the JSON body POSTed to subscribers.
+type webhookPayload struct {
+	Event      string    `json:"event"`
+	Data       User      `json:"data"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// enqueueWebhook stores one pending delivery per subscriber in urls.
+// Call it inside the same transaction as the user change, so an event is
+// recorded if and only if the change commits.
+func enqueueWebhook(ctx context.Context, db execer, urls []string, event string, u User) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(webhookPayload{Event: event, Data: u, OccurredAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx,
+		`INSERT INTO webhook_deliveries (url, event, payload)
+		 SELECT unnest($1::text[]), $2, $3`,
+		urls, event, body,
+	)
+	return err
+}
+
+// webhookDelivery is a claimed row from webhook_deliveries.
+type webhookDelivery struct {
+	ID       int64
+	URL      string
+	Event    string
+	Payload  []byte
+	Attempts int
+}
+
+// runWebhookWorker delivers pending webhooks until ctx is cancelled.
+// Rows are claimed with SKIP LOCKED and a lease, so several replicas can run
+// the worker side by side and a crashed worker's claims are retried later.
+func runWebhookWorker(ctx context.Context, db *pgxpool.Pool, cfg Config) {
+	if len(cfg.WebhookURLs) == 0 {
+		return
+	}
+	if cfg.WebhookSecret == "" {
+		log.Println("⚠️ WEBHOOK_SECRET is empty; webhook signatures are not secret")
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		batch, err := claimWebhooks(ctx, db)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("❌ webhook worker: claim failed: %v", err)
+			}
+			continue
+		}
+		for _, d := range batch {
+			deliverWebhook(ctx, db, cfg, d)
+		}
+	}
+}
+
+// claimWebhooks leases up to webhookBatchSize due deliveries for webhookLease.
+func claimWebhooks(ctx context.Context, db *pgxpool.Pool) ([]webhookDelivery, error) {
+	rows, err := db.Query(ctx,
+		`UPDATE webhook_deliveries
+		 SET next_attempt_at = now() + $1 * interval '1 second'
+		 WHERE id IN (
+		   SELECT id FROM webhook_deliveries
+		   WHERE status = 'pending' AND next_attempt_at <= now()
+		   ORDER BY id
+		   LIMIT $2
+		   FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING id, url, event, payload, attempts`,
+		int(webhookLease.Seconds()), webhookBatchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (webhookDelivery, error) {
+		var d webhookDelivery
+		err := row.Scan(&d.ID, &d.URL, &d.Event, &d.Payload, &d.Attempts)
+		return d, err
+	})
+}
+
+// deliverWebhook makes one attempt and records the outcome.
+// Failures back off exponentially (2^attempts seconds, capped at 1h);
+// after WEBHOOK_MAX_ATTEMPTS the delivery is marked dead and logged.
+func deliverWebhook(ctx context.Context, db *pgxpool.Pool, cfg Config, d webhookDelivery) {
+	code, err := postWebhook(ctx, d, cfg.WebhookSecret)
+	attempts := d.Attempts + 1
+
+	if err == nil {
+		_, err := db.Exec(ctx,
+			`UPDATE webhook_deliveries
+			 SET status='delivered', attempts=$2, last_status_code=$3, last_error=NULL, updated_at=now()
+			 WHERE id=$1`,
+			d.ID, attempts, code,
+		)
+		if err != nil {
+			log.Printf("❌ webhook %d: failed to record delivery: %v", d.ID, err)
+		}
+		return
+	}
+
+	status := "pending"
+	if attempts >= cfg.WebhookMaxAttempts {
+		status = "dead"
+		log.Printf("☠️ webhook %d (%s -> %s) dead-lettered after %d attempts: %v", d.ID, d.Event, d.URL, attempts, err)
+	}
+	backoff := min(1<<min(attempts, 12), 3600) // seconds
+	_, uerr := db.Exec(ctx,
+		`UPDATE webhook_deliveries
+		 SET status=$2, attempts=$3, last_status_code=NULLIF($4, 0), last_error=$5,
+		     next_attempt_at=now() + $6 * interval '1 second', updated_at=now()
+		 WHERE id=$1`,
+		d.ID, status, attempts, code, err.Error(), backoff,
+	)
+	if uerr != nil {
+		log.Printf("❌ webhook %d: failed to record attempt: %v", d.ID, uerr)
+	}
+}
+
+// postWebhook sends a signed delivery and returns the response status code.
+// Any non-2xx response counts as a failure.
+func postWebhook(ctx context.Context, d webhookDelivery, secret string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(d.Payload, secret))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body under secret (WEBHOOK_SECRET).
+// Subscribers recompute it over the raw request body to verify authenticity.
+func signWebhook(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// registerWebhookAdminRoutes mounts the delivery inspection endpoints on an admin group.
+//
+//	GET  /webhooks/deliveries            recent deliveries, newest first (?status=, ?limit=, ?offset=)
+//	POST /webhooks/deliveries/:id/retry  force another attempt now
+func registerWebhookAdminRoutes(g *gin.RouterGroup, db *pgxpool.Pool) {
+	g.GET("/webhooks/deliveries", func(c *gin.Context) {
+		limit, offset := 50, 0
+		if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+			limit = n
+		}
+		if n, err := strconv.Atoi(c.Query("offset")); err == nil && n >= 0 {
+			offset = n
+		}
+		status := c.Query("status")
+
+		rows, err := db.Query(c,
+			`SELECT id, url, event, status, attempts, last_status_code, last_error, next_attempt_at, created_at, updated_at
+			 FROM webhook_deliveries
+			 WHERE $1 = '' OR status = $1
+			 ORDER BY id DESC
+			 LIMIT $2 OFFSET $3`,
+			status, limit, offset,
+		)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByPos[deliveryStatus])
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+
+		resp := struct {
+			ListResponse[deliveryStatus]
+			Status string `json:"status"`
+		}{ListResponse: newList(deliveries), Status: status}
+		resp.Limit, resp.Offset = limit, &offset
+		c.JSON(http.StatusOK, resp)
+	})
+
+	g.POST("/webhooks/deliveries/:id/retry", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			respondError(c, http.StatusBadRequest, gin.H{"error": "invalid delivery id"})
+			return
+		}
+
+		// Re-queue for immediate delivery; a dead delivery gets one more attempt.
+		rows, err := db.Query(c,
+			`UPDATE webhook_deliveries
+			 SET status='pending', next_attempt_at=now(), updated_at=now()
+			 WHERE id=$1
+			 RETURNING id, url, event, status, attempts, last_status_code, last_error, next_attempt_at, created_at, updated_at`,
+			id,
+		)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		d, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByPos[deliveryStatus])
+		if errors.Is(err, pgx.ErrNoRows) {
+			respondError(c, http.StatusNotFound, gin.H{"error": "delivery not found"})
+			return
+		}
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, d)
+	})
+}
+
+// deliveryStatus is the admin view of a webhook_deliveries row (payload omitted).
+type deliveryStatus struct {
+	ID             int64     `json:"id"`
+	URL            string    `json:"url"`
+	Event          string    `json:"event"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	LastStatusCode *int      `json:"last_status_code"`
+	LastError      *string   `json:"last_error"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}